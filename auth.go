@@ -0,0 +1,54 @@
+package encid
+
+import "context"
+
+// Authenticated wraps a KeyStore so that it is a [Versioner]
+// reporting a version of at least 2,
+// and a [KeyVersioner] doing the same for each of its keys.
+//
+// Encoding with the resulting KeyStore uses the version-2 encoding,
+// which can be authenticated when decoding.
+// Decoding with it rejects inputs that were forged or mistyped
+// with an error wrapping [ErrInvalidToken].
+// This allows callers with a version-1 keystore to get tamper detection
+// without migrating the keystore.
+//
+// Strings encoded with the unwrapped version-1 keystore cannot be decoded with the wrapper,
+// and vice versa.
+func Authenticated(ks KeyStore) KeyStore {
+	return authKeyStore{KeyStore: ks}
+}
+
+type authKeyStore struct {
+	KeyStore
+}
+
+var (
+	_ Versioner         = authKeyStore{}
+	_ KeyVersioner      = authKeyStore{}
+	_ KeyEncoder        = authKeyStore{}
+	_ VersionedKeyStore = authKeyStore{}
+)
+
+func (a authKeyStore) Version() int {
+	return max(2, version(a.KeyStore))
+}
+
+func (a authKeyStore) KeyVersion(ctx context.Context, keyID int64) (int, error) {
+	v, err := keyVersion(ctx, a.KeyStore, keyID)
+	return max(2, v), err
+}
+
+func (a authKeyStore) VersionedDecoderByID(ctx context.Context, keyID int64) (int, func(dst, src []byte), int, error) {
+	typ, dec, v, err := decoderByID(ctx, a.KeyStore, keyID)
+	return typ, dec, max(2, v), err
+}
+
+func (a authKeyStore) VersionedEncoderByType(ctx context.Context, typ int) (int64, func(dst, src []byte), int, error) {
+	keyID, enc, v, err := encoderByType(ctx, a.KeyStore, typ)
+	return keyID, enc, max(2, v), err
+}
+
+func (a authKeyStore) EncoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), error) {
+	return encoderByID(ctx, a.KeyStore, id)
+}
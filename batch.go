@@ -0,0 +1,296 @@
+package encid
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+
+	"github.com/bobg/basexx/v2"
+	"github.com/bobg/errors"
+)
+
+// DecoderBatcher is an optional interface that KeyStores may implement
+// to look up several keys by ID in a single operation.
+// It is used by [DecodeBatch].
+type DecoderBatcher interface {
+	// DecodersByID looks up the keys with the given IDs.
+	// The result maps each ID to a [Decoder].
+	// IDs for which no key is found may be absent from the result,
+	// or may map to a Decoder whose Err is ErrNotFound.
+	DecodersByID(context.Context, []int64) (map[int64]Decoder, error)
+}
+
+// Decoder is the result of looking up one key with [DecoderBatcher].
+type Decoder struct {
+	// Type is the type of the key.
+	Type int
+
+	// Version is the encoding version of the key.
+	// See [KeyVersioner].
+	Version int
+
+	// Decrypt is a function for decrypting a data block using the key,
+	// as returned by KeyStore.DecoderByID.
+	Decrypt func(dst, src []byte)
+
+	// Encrypt is a function for encrypting a data block using the key,
+	// as returned by [KeyEncoder.EncoderByID].
+	// It is optional,
+	// but without it decoding the short format (see [WithShortFormat])
+	// looks up each key again.
+	Encrypt func(dst, src []byte)
+
+	// Err is an error looking up this key,
+	// such as ErrRevoked.
+	// If it is non-nil, the other fields are ignored.
+	Err error
+}
+
+// Encoded is a key ID and a string encoded with it.
+type Encoded struct {
+	KeyID int64
+	Str   string
+}
+
+// EncodeResult is one result of [EncodeBatch].
+type EncodeResult struct {
+	Encoded
+	Err error
+}
+
+// DecodeResult is one result of [DecodeBatch].
+type DecodeResult struct {
+	Type int
+	N    int64
+	Err  error
+}
+
+// EncodeBatch encodes each of the numbers in ns
+// using a key of the given type from the given keystore.
+// It is like calling [Encode] for each number,
+// but the key is looked up only once.
+// The results are in the same order as ns.
+func EncodeBatch(ctx context.Context, ks KeyStore, typ int, ns []int64, opts ...Option) []EncodeResult {
+	c := *newConfig(ks, opts)
+	c.ks = newMemoKeyStore(c.ks)
+
+	result := make([]EncodeResult, len(ns))
+	for i, n := range ns {
+		r := &result[i]
+		r.KeyID, r.Str, r.Err = c.encode(ctx, typ, n)
+	}
+	return result
+}
+
+// EncodeBatch50 is the same as EncodeBatch but it expresses the encrypted strings in base 50.
+// See [Encode50].
+func EncodeBatch50(ctx context.Context, ks KeyStore, typ int, ns []int64) []EncodeResult {
+	return encodeBatch(ctx, ks, typ, ns, rand.Reader, basexx.Base50)
+}
+
+func encodeBatch(ctx context.Context, ks KeyStore, typ int, ns []int64, randBytes io.Reader, base basexx.Base) []EncodeResult {
+	memo := newMemoKeyStore(ks)
+
+	result := make([]EncodeResult, len(ns))
+	for i, n := range ns {
+		r := &result[i]
+		r.KeyID, r.Str, r.Err = encode(ctx, memo, typ, n, randBytes, base)
+	}
+	return result
+}
+
+// DecodeBatch decodes each of the keyID/string pairs in inps.
+// It is like calling [Decode] for each pair,
+// but each distinct key is looked up only once.
+// If the keystore is a [DecoderBatcher],
+// all the keys are looked up in a single operation.
+// The results are in the same order as inps.
+func DecodeBatch(ctx context.Context, ks KeyStore, inps []Encoded, opts ...Option) []DecodeResult {
+	c := newConfig(ks, opts)
+
+	var (
+		result     = make([]DecodeResult, len(inps))
+		normalized []Encoded
+		indexes    []int // indexes[j] is the position in inps of normalized[j]
+		prefixTyps = make([]int, len(inps))
+		hasPrefix  = make([]bool, len(inps))
+	)
+	for i, inp := range inps {
+		var str string
+		prefixTyps[i], str, hasPrefix[i] = c.splitPrefix(inp.Str)
+
+		// Inputs failing the checksum are not looked up in the keystore.
+		str, err := c.stripCheck(c.normalize(str))
+		if err != nil {
+			result[i].Err = err
+			continue
+		}
+
+		normalized = append(normalized, Encoded{KeyID: inp.KeyID, Str: str})
+		indexes = append(indexes, i)
+	}
+
+	for j, r := range decodeBatch(ctx, c.ks, normalized, c.base, c.blockOpts) {
+		i := indexes[j]
+		if r.Err == nil {
+			r.Err = c.check(r.Type, prefixTyps[i], hasPrefix[i])
+		}
+		result[i] = r
+	}
+	return result
+}
+
+// DecodeBatch50 decodes each of the keyID/string pairs in inps,
+// which were produced by [EncodeBatch50] or [Encode50].
+// See [DecodeBatch].
+func DecodeBatch50(ctx context.Context, ks KeyStore, inps []Encoded) []DecodeResult {
+	return decodeBatch(ctx, ks, inps, basexx.Base50, blockOpts{})
+}
+
+func decodeBatch(ctx context.Context, ks KeyStore, inps []Encoded, base basexx.Base, bo blockOpts) []DecodeResult {
+	result := make([]DecodeResult, len(inps))
+
+	memo := newMemoKeyStore(ks)
+	if err := memo.prefetch(ctx, inps); err != nil {
+		for i := range result {
+			result[i].Err = err
+		}
+		return result
+	}
+
+	for i, inp := range inps {
+		r := &result[i]
+		r.Type, r.N, r.Err = decode(ctx, memo, inp.KeyID, inp.Str, base, bo)
+	}
+	return result
+}
+
+// memoKeyStore is a KeyStore that remembers the results of an underlying KeyStore,
+// for the duration of a batch operation.
+type memoKeyStore struct {
+	ks         KeyStore
+	decoders   map[int64]Decoder
+	encoders   map[int]encoderResult
+	idEncoders map[int64]idEncoderResult
+}
+
+type encoderResult struct {
+	id  int64
+	enc func(dst, src []byte)
+	ver int
+	err error
+}
+
+type idEncoderResult struct {
+	typ int
+	enc func(dst, src []byte)
+	err error
+}
+
+var (
+	_ Versioner         = &memoKeyStore{}
+	_ KeyVersioner      = &memoKeyStore{}
+	_ KeyEncoder        = &memoKeyStore{}
+	_ VersionedKeyStore = &memoKeyStore{}
+)
+
+func newMemoKeyStore(ks KeyStore) *memoKeyStore {
+	return &memoKeyStore{
+		ks:         ks,
+		decoders:   make(map[int64]Decoder),
+		encoders:   make(map[int]encoderResult),
+		idEncoders: make(map[int64]idEncoderResult),
+	}
+}
+
+// prefetch looks up the keys needed for inps in a single operation,
+// if the underlying KeyStore is a DecoderBatcher.
+func (m *memoKeyStore) prefetch(ctx context.Context, inps []Encoded) error {
+	batcher, ok := m.ks.(DecoderBatcher)
+	if !ok {
+		return nil
+	}
+
+	var (
+		ids  []int64
+		seen = make(map[int64]bool)
+	)
+	for _, inp := range inps {
+		if !seen[inp.KeyID] {
+			seen[inp.KeyID] = true
+			ids = append(ids, inp.KeyID)
+		}
+	}
+
+	decoders, err := batcher.DecodersByID(ctx, ids)
+	if err != nil {
+		return errors.Wrap(err, "getting keys from keystore")
+	}
+
+	for _, id := range ids {
+		d, ok := decoders[id]
+		if !ok {
+			d = Decoder{Err: ErrNotFound}
+		}
+		m.decoders[id] = d
+		if d.Err != nil || d.Encrypt != nil {
+			m.idEncoders[id] = idEncoderResult{typ: d.Type, enc: d.Encrypt, err: d.Err}
+		}
+	}
+
+	return nil
+}
+
+func (m *memoKeyStore) decoder(ctx context.Context, id int64) Decoder {
+	if d, ok := m.decoders[id]; ok {
+		return d
+	}
+
+	var d Decoder
+	d.Type, d.Decrypt, d.Version, d.Err = decoderByID(ctx, m.ks, id)
+	m.decoders[id] = d
+
+	return d
+}
+
+func (m *memoKeyStore) DecoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), error) {
+	d := m.decoder(ctx, id)
+	return d.Type, d.Decrypt, d.Err
+}
+
+func (m *memoKeyStore) VersionedDecoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), int, error) {
+	d := m.decoder(ctx, id)
+	return d.Type, d.Decrypt, d.Version, d.Err
+}
+
+func (m *memoKeyStore) EncoderByType(ctx context.Context, typ int) (int64, func(dst, src []byte), error) {
+	id, enc, _, err := m.VersionedEncoderByType(ctx, typ)
+	return id, enc, err
+}
+
+func (m *memoKeyStore) VersionedEncoderByType(ctx context.Context, typ int) (int64, func(dst, src []byte), int, error) {
+	e, ok := m.encoders[typ]
+	if !ok {
+		e.id, e.enc, e.ver, e.err = encoderByType(ctx, m.ks, typ)
+		m.encoders[typ] = e
+	}
+	return e.id, e.enc, e.ver, e.err
+}
+
+func (m *memoKeyStore) Version() int {
+	return version(m.ks)
+}
+
+func (m *memoKeyStore) KeyVersion(ctx context.Context, id int64) (int, error) {
+	d := m.decoder(ctx, id)
+	return d.Version, d.Err
+}
+
+func (m *memoKeyStore) EncoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), error) {
+	e, ok := m.idEncoders[id]
+	if !ok {
+		e.typ, e.enc, e.err = encoderByID(ctx, m.ks, id)
+		m.idEncoders[id] = e
+	}
+	return e.typ, e.enc, e.err
+}
@@ -0,0 +1,180 @@
+package encid_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bobg/encid"
+	"github.com/bobg/encid/testutil"
+)
+
+// countingKeyStore counts lookups in an underlying keystore.
+type countingKeyStore struct {
+	testutil.KeyStore
+	decoders, encoders, idEncoders, versions int
+}
+
+func (c *countingKeyStore) DecoderByID(ctx context.Context, keyID int64) (int, func(dst, src []byte), error) {
+	c.decoders++
+	return c.KeyStore.DecoderByID(ctx, keyID)
+}
+
+func (c *countingKeyStore) EncoderByType(ctx context.Context, typ int) (int64, func(dst, src []byte), error) {
+	c.encoders++
+	return c.KeyStore.EncoderByType(ctx, typ)
+}
+
+func (c *countingKeyStore) KeyVersion(ctx context.Context, keyID int64) (int, error) {
+	c.versions++
+	return c.KeyStore.KeyVersion(ctx, keyID)
+}
+
+func (c *countingKeyStore) EncoderByID(ctx context.Context, keyID int64) (int, func(dst, src []byte), error) {
+	c.idEncoders++
+	return c.KeyStore.EncoderByID(ctx, keyID)
+}
+
+// batchingKeyStore is a countingKeyStore that is also a DecoderBatcher.
+type batchingKeyStore struct {
+	*countingKeyStore
+	batches int
+}
+
+func (b *batchingKeyStore) DecodersByID(ctx context.Context, ids []int64) (map[int64]encid.Decoder, error) {
+	b.batches++
+	result := make(map[int64]encid.Decoder)
+	for _, id := range ids {
+		var d encid.Decoder
+		d.Type, d.Decrypt, d.Err = b.KeyStore.DecoderByID(ctx, id)
+		if d.Err == nil {
+			_, d.Encrypt, d.Err = b.KeyStore.EncoderByID(ctx, id)
+		}
+		result[id] = d
+	}
+	return result, nil
+}
+
+func TestBatch(t *testing.T) {
+	var (
+		ctx = context.Background()
+		ks  = &countingKeyStore{KeyStore: testutil.KeyStore{NumTypes: 100, Ver: 2}}
+		ns  = []int64{0, 1, 17, 1 << 40}
+	)
+
+	encoded := encid.EncodeBatch(ctx, ks, 7, ns)
+	if len(encoded) != len(ns) {
+		t.Fatalf("got %d results, want %d", len(encoded), len(ns))
+	}
+	if ks.encoders != 1 {
+		t.Errorf("got %d encoder lookups, want 1", ks.encoders)
+	}
+
+	var inps []encid.Encoded
+	for i, r := range encoded {
+		if r.Err != nil {
+			t.Fatalf("encoding %d: %s", ns[i], r.Err)
+		}
+		inps = append(inps, r.Encoded)
+	}
+
+	// Add a bad input in the middle and one for another key.
+	inps = append(inps[:2], append([]encid.Encoded{{KeyID: 7, Str: "xyzzy"}}, inps[2:]...)...)
+	_, str, err := encid.Encode(ctx, ks, 8, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inps = append(inps, encid.Encoded{KeyID: 8, Str: str})
+
+	ks.decoders = 0
+	decoded := encid.DecodeBatch(ctx, ks, inps)
+	if len(decoded) != len(inps) {
+		t.Fatalf("got %d results, want %d", len(decoded), len(inps))
+	}
+	if ks.decoders != 2 {
+		t.Errorf("got %d decoder lookups, want 2", ks.decoders)
+	}
+
+	want := []int64{0, 1, -1, 17, 1 << 40, 42}
+	for i, r := range decoded {
+		if want[i] < 0 {
+			if !errors.Is(r.Err, encid.ErrInvalidToken) {
+				t.Errorf("result %d: got error %v, want %v", i, r.Err, encid.ErrInvalidToken)
+			}
+			continue
+		}
+		if r.Err != nil {
+			t.Errorf("result %d: %s", i, r.Err)
+			continue
+		}
+		if r.N != want[i] {
+			t.Errorf("result %d: got %d, want %d", i, r.N, want[i])
+		}
+	}
+
+	encoded = encid.EncodeBatch50(ctx, ks, 7, ns)
+	inps = nil
+	for _, r := range encoded {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		inps = append(inps, r.Encoded)
+	}
+	for i, r := range encid.DecodeBatch50(ctx, ks, inps) {
+		if r.Err != nil {
+			t.Errorf("base 50 result %d: %s", i, r.Err)
+		} else if r.N != ns[i] {
+			t.Errorf("base 50 result %d: got %d, want %d", i, r.N, ns[i])
+		}
+	}
+}
+
+func TestBatchShort(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		ks    = &countingKeyStore{KeyStore: testutil.KeyStore{NumTypes: 100, Ver: 2}}
+		short = encid.WithShortFormat()
+		ns    = []int64{0, 1, 17, 1 << 40}
+	)
+
+	var inps []encid.Encoded
+	for _, typ := range []int{7, 8} {
+		for i, r := range encid.EncodeBatch(ctx, ks, typ, ns, short) {
+			if r.Err != nil {
+				t.Fatalf("encoding %d: %s", ns[i], r.Err)
+			}
+			inps = append(inps, r.Encoded)
+		}
+	}
+
+	check := func(t *testing.T, decoded []encid.DecodeResult) {
+		t.Helper()
+		for i, r := range decoded {
+			if r.Err != nil {
+				t.Errorf("result %d: %s", i, r.Err)
+			} else if r.N != ns[i%len(ns)] {
+				t.Errorf("result %d: got %d, want %d", i, r.N, ns[i%len(ns)])
+			}
+		}
+	}
+
+	t.Run("memo", func(t *testing.T) {
+		ks.idEncoders = 0
+		check(t, encid.DecodeBatch(ctx, ks, inps, short))
+		if ks.idEncoders != 2 {
+			t.Errorf("got %d encoder lookups, want 2", ks.idEncoders)
+		}
+	})
+
+	t.Run("prefetch", func(t *testing.T) {
+		b := &batchingKeyStore{countingKeyStore: ks}
+		ks.decoders, ks.idEncoders = 0, 0
+		check(t, encid.DecodeBatch(ctx, b, inps, short))
+		if b.batches != 1 {
+			t.Errorf("got %d batch lookups, want 1", b.batches)
+		}
+		if ks.decoders != 0 || ks.idEncoders != 0 {
+			t.Errorf("got %d decoder and %d encoder lookups, want 0", ks.decoders, ks.idEncoders)
+		}
+	})
+}
@@ -0,0 +1,362 @@
+// Package cache provides a caching wrapper for an encid.KeyStore.
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bobg/encid"
+	"github.com/bobg/encid/internal/keydb"
+)
+
+// KeyStore is an [encid.KeyStore] that caches the results of another KeyStore.
+// Without it,
+// each call to encid.Encode or encid.Decode
+// may require a database query and the construction of a new cipher.
+//
+// Only successful lookups are cached.
+// Changes to the underlying keystore,
+// such as rotating, retiring, or revoking a key,
+// are not seen until the affected entries expire or are invalidated
+// (see [KeyStore.Invalidate], [KeyStore.InvalidateID], and [KeyStore.InvalidateType]).
+//
+// Entries are cached separately for each tenant
+// selected in the context with [github.com/bobg/encid/sqlite.WithTenant]
+// or [github.com/bobg/encid/postgres.WithTenant],
+// so one KeyStore can wrap a keystore shared by many tenants.
+//
+// A KeyStore is safe for concurrent use
+// if the underlying keystore is.
+type KeyStore struct {
+	ks  encid.KeyStore
+	ttl time.Duration
+	now func() time.Time
+
+	mu       sync.Mutex
+	decoders *lru[cacheKey[int64], decoder]
+	encoders *lru[cacheKey[int], encoder]
+	versions *lru[cacheKey[int64], int]
+
+	// idEncoders holds the results of EncoderByID.
+	idEncoders *lru[cacheKey[int64], idEncoder]
+}
+
+// cacheKey is the key of a cache entry:
+// a key ID or a type,
+// plus the tenant selected in the context,
+// since the underlying keystore's answer depends on it.
+type cacheKey[K comparable] struct {
+	tenant string
+	k      K
+}
+
+func newCacheKey[K comparable](ctx context.Context, k K) cacheKey[K] {
+	return cacheKey[K]{tenant: keydb.Tenant(ctx), k: k}
+}
+
+type decoder struct {
+	typ int
+	dec func(dst, src []byte)
+}
+
+type encoder struct {
+	id  int64
+	enc func(dst, src []byte)
+}
+
+type idEncoder struct {
+	typ int
+	enc func(dst, src []byte)
+}
+
+var (
+	_ encid.KeyStore          = &KeyStore{}
+	_ encid.Versioner         = &KeyStore{}
+	_ encid.KeyVersioner      = &KeyStore{}
+	_ encid.KeyEncoder        = &KeyStore{}
+	_ encid.VersionedKeyStore = &KeyStore{}
+)
+
+// New creates a new caching wrapper for ks.
+// Each of its caches (decoders by key ID, encoders by type, encoders by key ID, and key versions)
+// holds at most size entries,
+// evicting the least recently used ones as needed.
+// A size of zero or less means no limit.
+// Cache entries expire after ttl.
+// A ttl of zero or less means entries never expire.
+func New(ks encid.KeyStore, size int, ttl time.Duration) *KeyStore {
+	return &KeyStore{
+		ks:       ks,
+		ttl:      ttl,
+		now:      time.Now,
+		decoders: newLRU[cacheKey[int64], decoder](size),
+		encoders: newLRU[cacheKey[int], encoder](size),
+		versions: newLRU[cacheKey[int64], int](size),
+
+		idEncoders: newLRU[cacheKey[int64], idEncoder](size),
+	}
+}
+
+func (c *KeyStore) expires(now time.Time) time.Time {
+	if c.ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(c.ttl)
+}
+
+// DecoderByID implements [encid.KeyStore].
+func (c *KeyStore) DecoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), error) {
+	var (
+		k   = newCacheKey(ctx, id)
+		now = c.now()
+	)
+
+	c.mu.Lock()
+	d, ok := c.decoders.get(k, now)
+	c.mu.Unlock()
+
+	if ok {
+		return d.typ, d.dec, nil
+	}
+
+	typ, dec, err := c.ks.DecoderByID(ctx, id)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	c.mu.Lock()
+	c.decoders.put(k, decoder{typ: typ, dec: dec}, c.expires(now))
+	c.mu.Unlock()
+
+	return typ, dec, nil
+}
+
+// VersionedDecoderByID implements [encid.VersionedKeyStore].
+// It fills the decoder and version caches
+// with a single lookup in the underlying keystore,
+// if that is a VersionedKeyStore too.
+func (c *KeyStore) VersionedDecoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), int, error) {
+	vks, ok := c.ks.(encid.VersionedKeyStore)
+	if !ok {
+		v, err := c.KeyVersion(ctx, id)
+		if err != nil {
+			return 0, nil, 0, err
+		}
+		typ, dec, err := c.DecoderByID(ctx, id)
+		return typ, dec, v, err
+	}
+
+	var (
+		k   = newCacheKey(ctx, id)
+		now = c.now()
+	)
+
+	c.mu.Lock()
+	d, dok := c.decoders.get(k, now)
+	v, vok := c.versions.get(k, now)
+	c.mu.Unlock()
+
+	if dok && vok {
+		return d.typ, d.dec, v, nil
+	}
+
+	typ, dec, v, err := vks.VersionedDecoderByID(ctx, id)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	c.mu.Lock()
+	c.decoders.put(k, decoder{typ: typ, dec: dec}, c.expires(now))
+	c.versions.put(k, v, c.expires(now))
+	c.mu.Unlock()
+
+	return typ, dec, v, nil
+}
+
+// EncoderByID implements [encid.KeyEncoder].
+// The underlying keystore must be a KeyEncoder too.
+func (c *KeyStore) EncoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), error) {
+	ke, ok := c.ks.(encid.KeyEncoder)
+	if !ok {
+		return 0, nil, fmt.Errorf("underlying keystore is not a KeyEncoder")
+	}
+
+	var (
+		k   = newCacheKey(ctx, id)
+		now = c.now()
+	)
+
+	c.mu.Lock()
+	e, ok := c.idEncoders.get(k, now)
+	c.mu.Unlock()
+
+	if ok {
+		return e.typ, e.enc, nil
+	}
+
+	typ, enc, err := ke.EncoderByID(ctx, id)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	c.mu.Lock()
+	c.idEncoders.put(k, idEncoder{typ: typ, enc: enc}, c.expires(now))
+	c.mu.Unlock()
+
+	return typ, enc, nil
+}
+
+// EncoderByType implements [encid.KeyStore].
+func (c *KeyStore) EncoderByType(ctx context.Context, typ int) (int64, func(dst, src []byte), error) {
+	var (
+		k   = newCacheKey(ctx, typ)
+		now = c.now()
+	)
+
+	c.mu.Lock()
+	e, ok := c.encoders.get(k, now)
+	c.mu.Unlock()
+
+	if ok {
+		return e.id, e.enc, nil
+	}
+
+	id, enc, err := c.ks.EncoderByType(ctx, typ)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	c.mu.Lock()
+	c.encoders.put(k, encoder{id: id, enc: enc}, c.expires(now))
+	c.mu.Unlock()
+
+	return id, enc, nil
+}
+
+// VersionedEncoderByType implements [encid.VersionedKeyStore].
+// It fills the encoder and version caches
+// with a single lookup in the underlying keystore,
+// if that is a VersionedKeyStore too.
+func (c *KeyStore) VersionedEncoderByType(ctx context.Context, typ int) (int64, func(dst, src []byte), int, error) {
+	vks, ok := c.ks.(encid.VersionedKeyStore)
+	if !ok {
+		id, enc, err := c.EncoderByType(ctx, typ)
+		if err != nil {
+			return 0, nil, 0, err
+		}
+		v, err := c.KeyVersion(ctx, id)
+		return id, enc, v, err
+	}
+
+	var (
+		k   = newCacheKey(ctx, typ)
+		now = c.now()
+	)
+
+	c.mu.Lock()
+	e, eok := c.encoders.get(k, now)
+	var (
+		v   int
+		vok bool
+	)
+	if eok {
+		v, vok = c.versions.get(cacheKey[int64]{tenant: k.tenant, k: e.id}, now)
+	}
+	c.mu.Unlock()
+
+	if eok && vok {
+		return e.id, e.enc, v, nil
+	}
+
+	id, enc, v, err := vks.VersionedEncoderByType(ctx, typ)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	c.mu.Lock()
+	c.encoders.put(k, encoder{id: id, enc: enc}, c.expires(now))
+	c.versions.put(cacheKey[int64]{tenant: k.tenant, k: id}, v, c.expires(now))
+	c.mu.Unlock()
+
+	return id, enc, v, nil
+}
+
+// Version implements [encid.Versioner].
+// It reports the version of the underlying keystore,
+// which is 1 if that is not a Versioner.
+func (c *KeyStore) Version() int {
+	if v, ok := c.ks.(encid.Versioner); ok {
+		return v.Version()
+	}
+	return 1
+}
+
+// KeyVersion implements [encid.KeyVersioner].
+// If the underlying keystore is not a KeyVersioner,
+// this is the same as Version.
+func (c *KeyStore) KeyVersion(ctx context.Context, id int64) (int, error) {
+	kv, ok := c.ks.(encid.KeyVersioner)
+	if !ok {
+		return c.Version(), nil
+	}
+
+	var (
+		k   = newCacheKey(ctx, id)
+		now = c.now()
+	)
+
+	c.mu.Lock()
+	v, ok := c.versions.get(k, now)
+	c.mu.Unlock()
+
+	if ok {
+		return v, nil
+	}
+
+	v, err := kv.KeyVersion(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	c.versions.put(k, v, c.expires(now))
+	c.mu.Unlock()
+
+	return v, nil
+}
+
+// Invalidate discards all cache entries.
+func (c *KeyStore) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.decoders.clear()
+	c.encoders.clear()
+	c.versions.clear()
+	c.idEncoders.clear()
+}
+
+// InvalidateID discards cache entries for the key with the given ID,
+// e.g. after retiring or revoking it,
+// in every tenant.
+func (c *KeyStore) InvalidateID(id int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.decoders.removeIf(func(k cacheKey[int64], _ decoder) bool { return k.k == id })
+	c.versions.removeIf(func(k cacheKey[int64], _ int) bool { return k.k == id })
+	c.idEncoders.removeIf(func(k cacheKey[int64], _ idEncoder) bool { return k.k == id })
+	c.encoders.removeIf(func(_ cacheKey[int], e encoder) bool { return e.id == id })
+}
+
+// InvalidateType discards the cached encoder for the given type,
+// e.g. after rotating its keys,
+// in every tenant.
+func (c *KeyStore) InvalidateType(typ int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.encoders.removeIf(func(k cacheKey[int], _ encoder) bool { return k.k == typ })
+}
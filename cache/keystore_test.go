@@ -0,0 +1,264 @@
+package cache
+
+import (
+	"context"
+	"crypto/aes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bobg/encid"
+	"github.com/bobg/encid/mem"
+	"github.com/bobg/encid/sqlite"
+	"github.com/bobg/encid/testutil"
+)
+
+// countingKeyStore counts calls to the methods of an underlying keystore.
+type countingKeyStore struct {
+	*mem.KeyStore
+	decoders, encoders, versions, idEncoders int
+}
+
+func (c *countingKeyStore) EncoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), error) {
+	c.idEncoders++
+	return c.KeyStore.EncoderByID(ctx, id)
+}
+
+func (c *countingKeyStore) DecoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), error) {
+	c.decoders++
+	return c.KeyStore.DecoderByID(ctx, id)
+}
+
+func (c *countingKeyStore) EncoderByType(ctx context.Context, typ int) (int64, func(dst, src []byte), error) {
+	c.encoders++
+	return c.KeyStore.EncoderByType(ctx, typ)
+}
+
+func (c *countingKeyStore) KeyVersion(ctx context.Context, id int64) (int, error) {
+	c.versions++
+	return c.KeyStore.KeyVersion(ctx, id)
+}
+
+// versionedKeyStore is a countingKeyStore that is also an encid.VersionedKeyStore.
+type versionedKeyStore struct {
+	*countingKeyStore
+	versioned int
+}
+
+func (v *versionedKeyStore) VersionedDecoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), int, error) {
+	v.versioned++
+	typ, dec, err := v.KeyStore.DecoderByID(ctx, id)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	ver, err := v.KeyStore.KeyVersion(ctx, id)
+	return typ, dec, ver, err
+}
+
+func (v *versionedKeyStore) VersionedEncoderByType(ctx context.Context, typ int) (int64, func(dst, src []byte), int, error) {
+	v.versioned++
+	id, enc, err := v.KeyStore.EncoderByType(ctx, typ)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	ver, err := v.KeyStore.KeyVersion(ctx, id)
+	return id, enc, ver, err
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+
+	under := &countingKeyStore{KeyStore: mem.New(nil)}
+	for typ := 1; typ <= 2; typ++ {
+		if _, err := under.NewKey(ctx, typ, aes.BlockSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Unix(1000000, 0)
+
+	c := New(under, 0, time.Minute)
+	c.now = func() time.Time { return now }
+
+	testutil.EncodeDecode(ctx, t, c, 3)
+
+	// One lookup of each kind for each of two keys.
+	if under.decoders != 2 || under.encoders != 2 || under.versions != 2 {
+		t.Errorf("got (decoders, encoders, versions) = (%d, %d, %d), want (2, 2, 2)", under.decoders, under.encoders, under.versions)
+	}
+
+	now = now.Add(2 * time.Minute)
+
+	if _, _, err := encid.Encode(ctx, c, 1, 17); err != nil {
+		t.Fatal(err)
+	}
+	if under.encoders != 3 {
+		t.Errorf("after expiration, got %d encoder lookups, want 3", under.encoders)
+	}
+
+	newID, err := under.Rotate(ctx, 1, aes.BlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _, err := c.EncoderByType(ctx, 1); err != nil || id == newID {
+		t.Errorf("before invalidation, got (%d, %v), want old key", id, err)
+	}
+	c.InvalidateType(1)
+	if id, _, err := c.EncoderByType(ctx, 1); err != nil || id != newID {
+		t.Errorf("after invalidation, got (%d, %v), want (%d, nil)", id, err, newID)
+	}
+
+	if err := under.Revoke(ctx, newID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.DecoderByID(ctx, newID); !errors.Is(err, encid.ErrRevoked) {
+		t.Errorf("got error %v, want %v", err, encid.ErrRevoked)
+	}
+	c.InvalidateID(newID)
+	if _, _, err := c.EncoderByType(ctx, 1); !errors.Is(err, encid.ErrNotFound) {
+		t.Errorf("after invalidating revoked key, got error %v, want %v", err, encid.ErrNotFound)
+	}
+
+	c.Invalidate()
+	before := under.decoders
+	if _, _, err := c.DecoderByID(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if under.decoders != before+1 {
+		t.Errorf("after Invalidate, got %d decoder lookups, want %d", under.decoders, before+1)
+	}
+}
+
+func TestCacheVersioned(t *testing.T) {
+	ctx := context.Background()
+
+	under := &versionedKeyStore{countingKeyStore: &countingKeyStore{KeyStore: mem.New(nil)}}
+	for typ := 1; typ <= 2; typ++ {
+		if _, err := under.NewKey(ctx, typ, aes.BlockSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := New(under, 0, time.Minute)
+
+	testutil.EncodeDecode(ctx, t, c, 3)
+
+	// One versioned lookup in each direction for each of two keys,
+	// and no separate version lookups.
+	if under.versioned != 4 {
+		t.Errorf("got %d versioned lookups, want 4", under.versioned)
+	}
+	if under.decoders != 0 || under.encoders != 0 || under.versions != 0 {
+		t.Errorf("got (decoders, encoders, versions) = (%d, %d, %d), want (0, 0, 0)", under.decoders, under.encoders, under.versions)
+	}
+}
+
+func TestCacheShort(t *testing.T) {
+	ctx := context.Background()
+
+	under := &countingKeyStore{KeyStore: mem.New(nil)}
+	if _, err := under.NewKey(ctx, 1, aes.BlockSize); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(under, 0, time.Minute)
+
+	short := encid.WithShortFormat()
+
+	tok, err := encid.EncodeToken(ctx, c, 1, 17, short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, n, err := encid.DecodeToken(ctx, c, tok, short); err != nil || n != 17 {
+			t.Errorf("decoding %s: got (%d, %v), want 17", tok, n, err)
+		}
+	}
+	if under.idEncoders != 1 {
+		t.Errorf("got %d encoder-by-ID lookups, want 1", under.idEncoders)
+	}
+}
+
+func TestCacheTenants(t *testing.T) {
+	ctx := context.Background()
+
+	under, err := sqlite.New(ctx, filepath.Join(t.TempDir(), "keystore.db"), aes.NewCipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := New(under, 10, 0)
+
+	var (
+		ctxA = sqlite.WithTenant(ctx, "a")
+		ctxB = sqlite.WithTenant(ctx, "b")
+	)
+
+	if _, err := under.NewKey(ctxA, 1, aes.BlockSize); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fill the caches in tenant a.
+	keyID, str, err := encid.Encode(ctxA, ks, 1, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, n, err := encid.Decode(ctxA, ks, keyID, str); err != nil || n != 17 {
+		t.Fatalf("decoding in tenant a: got (%d, %v), want 17", n, err)
+	}
+
+	// Tenant b must not see them.
+	if _, _, err := ks.EncoderByType(ctxB, 1); !errors.Is(err, encid.ErrNotFound) {
+		t.Errorf("encoding in tenant b: got error %v, want %v", err, encid.ErrNotFound)
+	}
+	if _, _, err := encid.Decode(ctxB, ks, keyID, str); !errors.Is(err, encid.ErrNotFound) {
+		t.Errorf("decoding in tenant b: got error %v, want %v", err, encid.ErrNotFound)
+	}
+	if _, err := ks.KeyVersion(ctxB, keyID); !errors.Is(err, encid.ErrNotFound) {
+		t.Errorf("getting key version in tenant b: got error %v, want %v", err, encid.ErrNotFound)
+	}
+	if _, _, err := ks.EncoderByID(ctxB, keyID); !errors.Is(err, encid.ErrNotFound) {
+		t.Errorf("getting encoder by ID in tenant b: got error %v, want %v", err, encid.ErrNotFound)
+	}
+
+	// Invalidation applies to every tenant.
+	if err := under.Revoke(ctxA, keyID); err != nil {
+		t.Fatal(err)
+	}
+	ks.InvalidateID(keyID)
+	if _, _, err := encid.Decode(ctxA, ks, keyID, str); !errors.Is(err, encid.ErrRevoked) {
+		t.Errorf("decoding in tenant a after revoking: got error %v, want %v", err, encid.ErrRevoked)
+	}
+}
+
+func TestLRU(t *testing.T) {
+	var (
+		now = time.Now()
+		c   = newLRU[int, string](2)
+	)
+
+	c.put(1, "one", time.Time{})
+	c.put(2, "two", time.Time{})
+	if _, ok := c.get(1, now); !ok {
+		t.Fatal("entry 1 missing")
+	}
+	c.put(3, "three", time.Time{})
+
+	if _, ok := c.get(2, now); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	if v, ok := c.get(1, now); !ok || v != "one" {
+		t.Errorf("got (%s, %v), want (one, true)", v, ok)
+	}
+	if v, ok := c.get(3, now); !ok || v != "three" {
+		t.Errorf("got (%s, %v), want (three, true)", v, ok)
+	}
+
+	c.put(4, "four", now.Add(time.Second))
+	if _, ok := c.get(4, now); !ok {
+		t.Error("entry 4 expired too soon")
+	}
+	if _, ok := c.get(4, now.Add(time.Second)); ok {
+		t.Error("entry 4 did not expire")
+	}
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"container/list"
+	"time"
+)
+
+// lru is a least-recently-used cache with optional expiration.
+// It is not safe for concurrent use.
+type lru[K comparable, V any] struct {
+	size  int // maximum number of entries, or 0 for unlimited
+	order *list.List
+	items map[K]*list.Element
+}
+
+type entry[K comparable, V any] struct {
+	key     K
+	val     V
+	expires time.Time // zero means never
+}
+
+func newLRU[K comparable, V any](size int) *lru[K, V] {
+	return &lru[K, V]{
+		size:  size,
+		order: list.New(),
+		items: make(map[K]*list.Element),
+	}
+}
+
+func (c *lru[K, V]) get(k K, now time.Time) (V, bool) {
+	el, ok := c.items[k]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	e := el.Value.(*entry[K, V])
+	if !e.expires.IsZero() && !now.Before(e.expires) {
+		c.order.Remove(el)
+		delete(c.items, k)
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	return e.val, true
+}
+
+func (c *lru[K, V]) put(k K, v V, expires time.Time) {
+	if el, ok := c.items[k]; ok {
+		e := el.Value.(*entry[K, V])
+		e.val, e.expires = v, expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[k] = c.order.PushFront(&entry[K, V]{key: k, val: v, expires: expires})
+	if c.size > 0 && c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*entry[K, V]).key)
+	}
+}
+
+// removeIf removes all entries whose keys and values satisfy pred.
+func (c *lru[K, V]) removeIf(pred func(K, V) bool) {
+	for k, el := range c.items {
+		if pred(k, el.Value.(*entry[K, V]).val) {
+			c.order.Remove(el)
+			delete(c.items, k)
+		}
+	}
+}
+
+func (c *lru[K, V]) clear() {
+	c.order.Init()
+	clear(c.items)
+}
@@ -0,0 +1,90 @@
+package encid
+
+import (
+	"github.com/bobg/basexx/v2"
+	"github.com/bobg/errors"
+)
+
+// ErrChecksum is the type of error produced when decoding an input
+// whose check character does not match the rest of it,
+// e.g. because of a typo.
+// See [WithCheckChar].
+//
+// The check character is verified before the keystore is consulted.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// WithCheckChar is an option that causes encoded strings and tokens
+// to end with a check character,
+// computed with the Luhn mod N algorithm over the digits of the base
+// (see [WithBase]).
+// This detects all single-character typos
+// and most transpositions of adjacent characters.
+// Decoding with this option verifies and removes the check character,
+// producing an error wrapping [ErrChecksum] if it doesn't match.
+//
+// The check character comes after any padding (see [WithPadding])
+// and does not cover any prefix (see [WithPrefix]).
+func WithCheckChar() Option {
+	return func(c *config) {
+		c.checkChar = true
+	}
+}
+
+// addCheck appends the check character to s,
+// if the check-character option is set.
+func (c *config) addCheck(s string) (string, error) {
+	if !c.checkChar {
+		return s, nil
+	}
+	sum, err := luhnSum(s, c.base, 2)
+	if err != nil {
+		return "", err
+	}
+	n := c.base.N()
+	ch, err := c.base.Encode((n - sum%n) % n)
+	if err != nil {
+		return "", err
+	}
+	return s + string(ch), nil
+}
+
+// stripCheck verifies and removes the check character at the end of s,
+// if the check-character option is set.
+func (c *config) stripCheck(s string) (string, error) {
+	if !c.checkChar {
+		return s, nil
+	}
+	if s == "" {
+		return "", errors.Wrap(ErrChecksum, "missing check character")
+	}
+	sum, err := luhnSum(s, c.base, 1)
+	if err != nil {
+		// A character that is not a digit of the base is a typo too.
+		return "", errors.Wrapf(err, "computing checksum of %s (%w)", s, ErrChecksum)
+	}
+	if sum%c.base.N() != 0 {
+		return "", errors.Wrapf(ErrChecksum, "input %s", s)
+	}
+	return s[:len(s)-1], nil
+}
+
+// luhnSum computes the Luhn mod N sum of the digits in s.
+// The rightmost digit is multiplied by factor,
+// which is 2 when computing a check character
+// and 1 when verifying one.
+func luhnSum(s string, base basexx.Base, factor int64) (int64, error) {
+	var (
+		n   = base.N()
+		sum int64
+	)
+	for i := len(s) - 1; i >= 0; i-- {
+		val, err := base.Decode(s[i])
+		if err != nil {
+			return 0, err
+		}
+		addend := factor * val
+		sum += addend/n + addend%n
+		factor = 3 - factor
+	}
+	return sum, nil
+}
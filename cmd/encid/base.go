@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bobg/basexx/v2"
+
+	"github.com/bobg/encid"
+)
+
+var bases = map[string]basexx.Base{
+	"30":          basexx.Base30,
+	"50":          basexx.Base50,
+	"crockford32": encid.Crockford32,
+	"58":          encid.Base58,
+	"64url":       encid.Base64URL,
+	"hex":         encid.Hex,
+}
+
+const baseDoc = "base for encoded strings: 30, 50, crockford32, 58, 64url, or hex"
+
+// parseBase returns the base with the given name.
+// The fifty flag (from -50) overrides the name.
+func parseBase(name string, fifty bool) (basexx.Base, error) {
+	if fifty {
+		return basexx.Base50, nil
+	}
+	if base, ok := bases[name]; ok {
+		return base, nil
+	}
+	return nil, fmt.Errorf("unknown base %q", name)
+}
@@ -5,31 +5,40 @@ import (
 	"crypto/aes"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 
+	"github.com/bobg/basexx/v2"
 	"github.com/bobg/errors"
 	"github.com/bobg/subcmd/v2"
 
 	"github.com/bobg/encid"
+	"github.com/bobg/encid/cache"
 	"github.com/bobg/encid/sqlite"
 )
 
 func main() {
-	if err := run(); err != nil {
-		log.Fatal(err)
+	out := output{w: os.Stdout}
+	if err := run(&out); err != nil {
+		out.fatal(err)
 	}
 }
 
-func run() error {
+func run(out *output) error {
 	ksfile, err := os.UserConfigDir()
 	if err != nil {
 		return errors.Wrap(err, "getting user config dir")
 	}
 	ksfile = filepath.Join(ksfile, "encid", "keystore.db")
 
+	var mkspec, tenant string
+
 	flag.StringVar(&ksfile, "keystore", ksfile, "pathname of keystore")
+	flag.StringVar(&mkspec, "masterkey", "", masterKeyDoc)
+	flag.StringVar(&tenant, "tenant", "", "tenant whose keys to use (default: the default tenant)")
+	flag.BoolVar(&out.json, "json", false, "write results and errors as JSON objects")
 	flag.Parse()
 
 	ksdir := filepath.Dir(ksfile)
@@ -44,96 +53,301 @@ func run() error {
 		return errors.Wrapf(err, "opening %s", ksfile)
 	}
 
-	c := maincmd{ks: ks}
+	kek, err := masterKey(ctx, ks, mkspec)
+	if err != nil {
+		return errors.Wrap(err, "getting master key")
+	}
+	if err := ks.SetMasterKey(ctx, kek); err != nil {
+		return errors.Wrap(err, "setting master key")
+	}
+
+	c := maincmd{ks: ks, out: *out}
 
-	return subcmd.Run(ctx, c, flag.Args())
+	return subcmd.Run(sqlite.WithTenant(ctx, tenant), c, flag.Args())
 }
 
+// Lookups in the keystore are cached while streaming.
+const (
+	streamCacheSize = 1024
+	streamCacheTTL  = time.Minute
+)
+
 type maincmd struct {
-	ks *sqlite.KeyStore
+	ks  *sqlite.KeyStore
+	out output
+}
+
+// codeKeyStore is the keystore used for encoding and decoding:
+// either the sqlite keystore or a cache in front of it.
+type codeKeyStore interface {
+	encid.KeyStore
+	encid.KeyVersioner
 }
 
 func (c maincmd) Subcmds() subcmd.Map {
 	return subcmd.Commands(
 		"enc", c.doEnc, "encode a number", subcmd.Params(
-			"-50", subcmd.Bool, false, "use base50",
+			"-50", subcmd.Bool, false, "use base50 (same as -base 50)",
+			"-base", subcmd.String, "30", baseDoc,
+			"-group", subcmd.Int, 0, "split output into hyphen-separated groups of this size",
+			"-short", subcmd.Bool, false, "use the short format",
+			"-stdin", subcmd.Bool, false, "encode numbers read from stdin, one per line",
+			"-csv", subcmd.Bool, false, "with -stdin, read and write CSV",
+			"-keep", subcmd.Bool, false, "with -stdin, copy extra input fields to the output",
 			"typ", subcmd.Int, 0, "type of number to encode",
-			"n", subcmd.Int64, 0, "number to encode",
+			"n?", subcmd.String, "", "number to encode (required without -stdin)",
 		),
 		"dec", c.doDec, "decode a number", subcmd.Params(
-			"-50", subcmd.Bool, false, "use base50",
-			"id", subcmd.Int64, 0, "id of decoding key",
-			"inp", subcmd.String, "", "input string to decode",
+			"-50", subcmd.Bool, false, "use base50 (same as -base 50)",
+			"-base", subcmd.String, "30", baseDoc,
+			"-short", subcmd.Bool, false, "accept the short format",
+			"-stdin", subcmd.Bool, false, "decode key IDs and strings read from stdin, one pair per line",
+			"-csv", subcmd.Bool, false, "with -stdin, read and write CSV",
+			"-keep", subcmd.Bool, false, "with -stdin, copy extra input fields to the output",
+			"id?", subcmd.Int64, 0, "id of decoding key (required without -stdin)",
+			"inp?", subcmd.String, "", "input string to decode (required without -stdin)",
 		),
 		"newkey", c.doNewKey, "create a new key", subcmd.Params(
+			"-version", subcmd.Int, 0, "version of key to create (default: keystore version)",
 			"typ", subcmd.Int, 0, "type of key to creae",
 		),
+		"migrate", c.doMigrate, "migrate keystore to version 2", nil,
+		"rotate", c.doRotate, "create a new key, making other keys of the same type decode-only", subcmd.Params(
+			"typ", subcmd.Int, 0, "type of key to rotate",
+		),
+		"decode-only", c.doDecodeOnly, "make a key decode-only", subcmd.Params(
+			"id", subcmd.Int64, 0, "id of key to make decode-only",
+		),
+		"revoke", c.doRevoke, "revoke a key", subcmd.Params(
+			"id", subcmd.Int64, 0, "id of key to revoke",
+		),
+		"rewrap", c.doRewrap, "re-wrap all keys under a new master key", subcmd.Params(
+			"spec", subcmd.String, "", "new master key (same forms as -masterkey, or \"none\")",
+		),
 	)
 }
 
-func (c maincmd) doEnc(ctx context.Context, fifty bool, typ int, n int64, _ []string) error {
-	return c.tryEnc(ctx, fifty, typ, n, false)
-}
+func (c maincmd) doEnc(ctx context.Context, fifty bool, basename string, group int, short, stdin, csv, keep bool, typ int, nstr string, _ []string) error {
+	base, err := parseBase(basename, fifty)
+	if err != nil {
+		return err
+	}
+	if _, err := base.Decode('-'); err == nil && group > 0 {
+		return fmt.Errorf("cannot use -group with base %s, which uses hyphens as digits", basename)
+	}
+	opts := codeOpts(base, short, encid.WithGroups(group))
 
-func (c maincmd) tryEnc(ctx context.Context, fifty bool, typ int, n int64, isRetry bool) error {
-	var (
-		id  int64
-		str string
-		err error
-	)
+	if stdin {
+		ks := cache.New(c.ks, streamCacheSize, streamCacheTTL)
+		sopts := streamOpts{CSV: csv, Keep: keep}
+		fn := func(fields []string) (*codeResult, error) {
+			n, err := strconv.ParseInt(fields[0], 10, 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing number %s", fields[0])
+			}
+			return c.encode(ctx, ks, base, opts, typ, n, false)
+		}
+		format := func(res *codeResult) []string {
+			return []string{strconv.FormatInt(res.KeyID, 10), res.Str}
+		}
+		if nstr != "" {
+			return fmt.Errorf("got number %s with -stdin", nstr)
+		}
+		return stdinStream(c.out, sopts, 1, fn, format)
+	}
 
-	if fifty {
-		id, str, err = encid.Encode50(ctx, c.ks, typ, n)
-	} else {
-		id, str, err = encid.Encode(ctx, c.ks, typ, n)
+	if nstr == "" {
+		return fmt.Errorf("missing number to encode (or use -stdin)")
+	}
+	n, err := strconv.ParseInt(nstr, 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "parsing number %s", nstr)
+	}
+
+	res, err := c.encode(ctx, c.ks, base, opts, typ, n, false)
+	if err != nil {
+		return err
 	}
+
+	return c.out.emit(res, fmt.Sprintf("%d %s\n", res.KeyID, res.Str))
+}
+
+func (c maincmd) encode(ctx context.Context, ks codeKeyStore, base basexx.Base, opts []encid.Option, typ int, n int64, isRetry bool) (*codeResult, error) {
+	id, str, err := encid.Encode(ctx, ks, typ, n, opts...)
 	if errors.Is(err, encid.ErrNotFound) && !isRetry {
-		if _, err = c.newKeyHelper(ctx, typ); err != nil {
-			return errors.Wrap(err, "creating new key")
+		// Create a key only for a type that has never had one.
+		// If all its keys are decode-only or revoked,
+		// that is for the user to fix.
+		has, herr := c.ks.HasKeys(ctx, typ)
+		if herr != nil {
+			return nil, herr
 		}
-		return c.tryEnc(ctx, fifty, typ, n, true)
+		if !has {
+			if _, err = c.newKeyHelper(ctx, typ); err != nil {
+				return nil, errors.Wrap(err, "creating new key")
+			}
+			return c.encode(ctx, ks, base, opts, typ, n, true)
+		}
+		err = errors.Wrapf(err, "no active key for type %d (use rotate or newkey)", typ)
 	}
 	if err != nil {
-		return errors.Wrap(err, "encoding")
+		return nil, errors.Wrap(err, "encoding")
 	}
 
-	fmt.Printf("%d %s\n", id, str)
+	return c.codeResult(ctx, ks, base, id, typ, n, str)
+}
+
+func (c maincmd) doDec(ctx context.Context, fifty bool, basename string, short, stdin, csv, keep bool, id int64, inp string, _ []string) error {
+	base, err := parseBase(basename, fifty)
+	if err != nil {
+		return err
+	}
+	opts := codeOpts(base, short)
+
+	if stdin {
+		ks := cache.New(c.ks, streamCacheSize, streamCacheTTL)
+		sopts := streamOpts{CSV: csv, Keep: keep}
+		fn := func(fields []string) (*codeResult, error) {
+			id, err := strconv.ParseInt(fields[0], 10, 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing key ID %s", fields[0])
+			}
+			return c.decode(ctx, ks, base, opts, id, fields[1])
+		}
+		format := func(res *codeResult) []string {
+			return []string{strconv.Itoa(res.Type), strconv.FormatInt(res.N, 10)}
+		}
+		if inp != "" {
+			return fmt.Errorf("got input string %s with -stdin", inp)
+		}
+		return stdinStream(c.out, sopts, 2, fn, format)
+	}
 
-	return nil
+	if inp == "" {
+		return fmt.Errorf("missing key ID and input string (or use -stdin)")
+	}
+
+	res, err := c.decode(ctx, c.ks, base, opts, id, inp)
+	if err != nil {
+		return err
+	}
+
+	return c.out.emit(res, fmt.Sprintf("%d %d\n", res.Type, res.N))
 }
 
-func (c maincmd) doDec(ctx context.Context, fifty bool, id int64, inp string, _ []string) error {
+func (c maincmd) decode(ctx context.Context, ks codeKeyStore, base basexx.Base, opts []encid.Option, id int64, inp string) (*codeResult, error) {
+	typ, n, err := encid.Decode(ctx, ks, id, inp, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding")
+	}
+
+	return c.codeResult(ctx, ks, base, id, typ, n, inp)
+}
+
+// codeOpts produces the options for encoding and decoding.
+func codeOpts(base basexx.Base, short bool, opts ...encid.Option) []encid.Option {
+	opts = append(opts, encid.WithBase(base))
+	if short {
+		opts = append(opts, encid.WithShortFormat())
+	}
+	return opts
+}
+
+// codeResult assembles the result of encoding or decoding.
+// The key version is needed only for JSON output.
+func (c maincmd) codeResult(ctx context.Context, ks codeKeyStore, base basexx.Base, id int64, typ int, n int64, str string) (*codeResult, error) {
+	res := &codeResult{
+		KeyID: id,
+		Type:  typ,
+		N:     n,
+		Str:   str,
+		Base:  int(base.N()),
+	}
+	if c.out.json {
+		var err error
+		if res.Version, err = ks.KeyVersion(ctx, id); err != nil {
+			return nil, errors.Wrapf(err, "getting version of key %d", id)
+		}
+	}
+	return res, nil
+}
+
+func (c maincmd) doNewKey(ctx context.Context, version, typ int, _ []string) error {
 	var (
-		typ int
-		n   int64
+		id  int64
 		err error
 	)
-
-	if fifty {
-		typ, n, err = encid.Decode50(ctx, c.ks, id, inp)
+	if version > 0 {
+		id, err = c.ks.NewKeyVersion(ctx, typ, aes.BlockSize, version)
 	} else {
-		typ, n, err = encid.Decode(ctx, c.ks, id, inp)
+		id, err = c.newKeyHelper(ctx, typ)
 	}
 	if err != nil {
-		return errors.Wrap(err, "decoding")
+		return err
+	}
+
+	return c.emitKey(ctx, id, &typ, encid.KeyActive, fmt.Sprintf("%d\n", id))
+}
+
+func (c maincmd) newKeyHelper(ctx context.Context, typ int) (int64, error) {
+	return c.ks.NewKey(ctx, typ, aes.BlockSize)
+}
+
+// emitKey writes a keyResult for the key with the given ID.
+func (c maincmd) emitKey(ctx context.Context, id int64, typ *int, state encid.KeyState, text string) error {
+	res := keyResult{KeyID: id, Type: typ}
+	if state != encid.KeyActive {
+		res.State = state.String()
+	}
+	if c.out.json {
+		var err error
+		if res.Version, err = c.ks.KeyVersion(ctx, id); err != nil {
+			return errors.Wrapf(err, "getting version of key %d", id)
+		}
+	}
+	return c.out.emit(res, text)
+}
+
+func (c maincmd) doMigrate(ctx context.Context, _ []string) error {
+	if err := c.ks.Migrate(ctx); err != nil {
+		return errors.Wrap(err, "migrating keystore")
 	}
 
-	fmt.Printf("%d %d\n", typ, n)
+	version := c.ks.Version()
 
-	return nil
+	return c.out.emit(versionResult{Version: version}, fmt.Sprintf("%d\n", version))
 }
 
-func (c maincmd) doNewKey(ctx context.Context, typ int, _ []string) error {
-	id, err := c.newKeyHelper(ctx, typ)
+func (c maincmd) doRotate(ctx context.Context, typ int, _ []string) error {
+	id, err := c.ks.Rotate(ctx, typ, aes.BlockSize)
 	if err != nil {
-		return err
+		return errors.Wrap(err, "rotating key")
 	}
 
-	fmt.Printf("%d\n", id)
+	return c.emitKey(ctx, id, &typ, encid.KeyActive, fmt.Sprintf("%d\n", id))
+}
 
-	return nil
+func (c maincmd) doDecodeOnly(ctx context.Context, id int64, _ []string) error {
+	if err := c.ks.Retire(ctx, id); err != nil {
+		return errors.Wrapf(err, "making key %d decode-only", id)
+	}
+	return c.emitKey(ctx, id, nil, encid.KeyDecodeOnly, "")
 }
 
-func (c maincmd) newKeyHelper(ctx context.Context, typ int) (int64, error) {
-	return c.ks.NewKey(ctx, typ, aes.BlockSize)
+func (c maincmd) doRevoke(ctx context.Context, id int64, _ []string) error {
+	if err := c.ks.Revoke(ctx, id); err != nil {
+		return errors.Wrapf(err, "revoking key %d", id)
+	}
+	return c.emitKey(ctx, id, nil, encid.KeyRevoked, "")
+}
+
+func (c maincmd) doRewrap(ctx context.Context, spec string, _ []string) error {
+	kek, err := masterKey(ctx, c.ks, spec)
+	if err != nil {
+		return errors.Wrap(err, "getting new master key")
+	}
+	if err := c.ks.Rewrap(ctx, kek); err != nil {
+		return errors.Wrap(err, "rewrapping keys")
+	}
+	return c.out.emit(rewrapResult{Wrapped: kek != nil}, "")
 }
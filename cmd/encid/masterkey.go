@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bobg/errors"
+
+	"github.com/bobg/encid/sqlite"
+)
+
+const masterKeyDoc = `master key for wrapping keys in the keystore:
+  env:VAR       hex-encoded key in environment variable VAR
+  file:PATH     hex-encoded key in file PATH
+  passenv:VAR   passphrase in environment variable VAR
+  passfile:PATH passphrase in file PATH`
+
+// masterKey parses a master-key spec (see masterKeyDoc) and produces the key it denotes.
+// An empty spec, or "none," denotes no master key.
+func masterKey(ctx context.Context, ks *sqlite.KeyStore, spec string) ([]byte, error) {
+	if spec == "" || spec == "none" {
+		return nil, nil
+	}
+
+	kind, arg, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("malformed master key spec %s", spec)
+	}
+
+	var val string
+
+	switch kind {
+	case "env", "passenv":
+		val = os.Getenv(arg)
+		if val == "" {
+			return nil, fmt.Errorf("environment variable %s not set", arg)
+		}
+
+	case "file", "passfile":
+		b, err := os.ReadFile(arg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", arg)
+		}
+		val = strings.TrimRight(string(b), "\r\n")
+
+	default:
+		return nil, fmt.Errorf("unknown master key spec type %s", kind)
+	}
+
+	if strings.HasPrefix(kind, "pass") {
+		return ks.PassphraseKey(ctx, []byte(val))
+	}
+
+	kek, err := hex.DecodeString(strings.TrimSpace(val))
+	return kek, errors.Wrap(err, "decoding hex master key")
+}
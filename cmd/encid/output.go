@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/bobg/errors"
+
+	"github.com/bobg/encid"
+)
+
+// output writes the results of subcommands,
+// either as text or, with the global -json flag, as JSON objects,
+// one per line.
+type output struct {
+	json bool
+	w    io.Writer
+}
+
+// emit writes v as a JSON object in JSON mode,
+// and text otherwise.
+func (o output) emit(v any, text string) error {
+	if o.json {
+		return o.encode(v)
+	}
+	_, err := io.WriteString(o.w, text)
+	return err
+}
+
+func (o output) encode(v any) error {
+	return errors.Wrap(json.NewEncoder(o.w).Encode(v), "writing JSON")
+}
+
+// fatal reports err and exits with a nonzero status.
+// In JSON mode the error is written as an [errResult].
+func (o output) fatal(err error) {
+	if o.json {
+		o.encode(newErrResult(0, err))
+		os.Exit(1)
+	}
+	log.Fatal(err)
+}
+
+// lineErr reports err for the given line of input in stream mode.
+func (o output) lineErr(errw io.Writer, line int, err error) error {
+	if o.json {
+		return o.encode(newErrResult(line, err))
+	}
+	_, err = fmt.Fprintf(errw, "line %d: %s\n", line, err)
+	return err
+}
+
+// codeResult is the result of encoding or decoding a number.
+type codeResult struct {
+	KeyID   int64    `json:"key_id"`
+	Type    int      `json:"type"`
+	N       int64    `json:"n"`
+	Str     string   `json:"str"`
+	Base    int      `json:"base"`
+	Version int      `json:"version"`
+	Extra   []string `json:"extra,omitempty"`
+}
+
+// keyResult is the result of an operation on a key.
+type keyResult struct {
+	KeyID   int64  `json:"key_id"`
+	Type    *int   `json:"type,omitempty"`
+	Version int    `json:"version,omitempty"`
+	State   string `json:"state,omitempty"`
+}
+
+// versionResult is the result of migrating the keystore.
+type versionResult struct {
+	Version int `json:"version"`
+}
+
+// rewrapResult is the result of rewrapping the keys in the keystore.
+type rewrapResult struct {
+	Wrapped bool `json:"wrapped"`
+}
+
+// errResult is an error in JSON mode.
+type errResult struct {
+	Error string `json:"error"`
+
+	// Code classifies the error when it is one of encid's sentinel errors,
+	// so that scripts need not parse Error.
+	Code string `json:"code,omitempty"`
+
+	// Line is the line of input where the error occurred in stream mode.
+	Line int `json:"line,omitempty"`
+}
+
+func newErrResult(line int, err error) errResult {
+	res := errResult{Error: err.Error(), Line: line}
+	switch {
+	case errors.Is(err, encid.ErrNotFound):
+		res.Code = "not_found"
+	case errors.Is(err, encid.ErrRevoked):
+		res.Code = "revoked"
+	case errors.Is(err, encid.ErrInvalidToken):
+		res.Code = "invalid_token"
+	}
+	return res
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bobg/errors"
+)
+
+// streamOpts are the options for reading records from stdin in enc and dec modes.
+type streamOpts struct {
+	// CSV means the input is CSV, and the output will be too.
+	// Otherwise the input is newline-delimited,
+	// with whitespace-separated fields,
+	// and the output is newline-delimited with space-separated fields.
+	CSV bool
+
+	// Keep means fields beyond those needed
+	// are copied to the output after the result fields.
+	Keep bool
+}
+
+// stream reads records from in and writes a result for each one to out.
+// Each record must have at least nfields fields.
+// The first nfields are passed to fn,
+// which produces the result.
+// In text mode,
+// format turns the result into the fields of an output record.
+//
+// An error from fn, or a record with too few fields,
+// is reported along with its line number
+// (to errw in text mode, and to out in JSON mode),
+// and processing continues with the next record.
+// If any record fails,
+// stream returns an error after the input is exhausted.
+func stream(in io.Reader, out output, errw io.Writer, opts streamOpts, nfields int, fn func([]string) (*codeResult, error), format func(*codeResult) []string) error {
+	bw := bufio.NewWriter(out.w)
+	out.w = bw
+
+	var (
+		r recordReader
+		w recordWriter
+	)
+	if opts.CSV {
+		cr := csv.NewReader(in)
+		cr.FieldsPerRecord = -1
+		r = &csvReader{r: cr}
+		w = &csvWriter{w: csv.NewWriter(bw)}
+	} else {
+		r = &lineReader{s: bufio.NewScanner(in)}
+		w = &lineWriter{w: bw}
+	}
+
+	flush := func() error {
+		if err := w.flush(); err != nil {
+			return errors.Wrap(err, "writing output")
+		}
+		return errors.Wrap(bw.Flush(), "writing output")
+	}
+
+	var nrecords, nfailed int
+
+	for {
+		fields, line, err := r.read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// Keep the results so far.
+			return errors.Join(errors.Wrapf(err, "reading line %d", line), flush())
+		}
+
+		nrecords++
+
+		res, err := streamRecord(fields, opts.Keep, nfields, fn)
+		if err != nil {
+			nfailed++
+			if err := out.lineErr(errw, line, err); err != nil {
+				return errors.Wrap(err, "writing error")
+			}
+			continue
+		}
+
+		if out.json {
+			err = out.encode(res)
+		} else {
+			err = w.write(append(format(res), res.Extra...))
+		}
+		if err != nil {
+			return errors.Wrap(err, "writing output")
+		}
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if nfailed > 0 {
+		return fmt.Errorf("%d of %d records failed", nfailed, nrecords)
+	}
+	return nil
+}
+
+func streamRecord(fields []string, keep bool, nfields int, fn func([]string) (*codeResult, error)) (*codeResult, error) {
+	if len(fields) < nfields {
+		return nil, fmt.Errorf("got %d field(s), want %d", len(fields), nfields)
+	}
+	res, err := fn(fields[:nfields])
+	if err != nil {
+		return nil, err
+	}
+	if keep {
+		res.Extra = fields[nfields:]
+	}
+	return res, nil
+}
+
+// stdinStream is stream with stdin and stderr.
+func stdinStream(out output, opts streamOpts, nfields int, fn func([]string) (*codeResult, error), format func(*codeResult) []string) error {
+	return stream(os.Stdin, out, os.Stderr, opts, nfields, fn, format)
+}
+
+type recordReader interface {
+	// read returns the next record and its line number.
+	// At the end of the input it returns io.EOF.
+	read() ([]string, int, error)
+}
+
+type recordWriter interface {
+	write([]string) error
+	flush() error
+}
+
+type lineReader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func (r *lineReader) read() ([]string, int, error) {
+	for r.s.Scan() {
+		r.line++
+		if fields := strings.Fields(r.s.Text()); len(fields) > 0 {
+			return fields, r.line, nil
+		}
+	}
+	if err := r.s.Err(); err != nil {
+		return nil, r.line + 1, err
+	}
+	return nil, r.line, io.EOF
+}
+
+type lineWriter struct {
+	w io.Writer
+}
+
+func (w *lineWriter) write(fields []string) error {
+	_, err := fmt.Fprintln(w.w, strings.Join(fields, " "))
+	return err
+}
+
+func (w *lineWriter) flush() error {
+	return nil
+}
+
+type csvReader struct {
+	r *csv.Reader
+}
+
+func (r *csvReader) read() ([]string, int, error) {
+	fields, err := r.r.Read()
+	if err != nil {
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			return nil, perr.Line, err
+		}
+		return nil, 0, err
+	}
+	line, _ := r.r.FieldPos(0)
+	return fields, line, nil
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (w *csvWriter) write(fields []string) error {
+	return w.w.Write(fields)
+}
+
+func (w *csvWriter) flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
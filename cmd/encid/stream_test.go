@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	// The function for each record doubles a number.
+	fn := func(fields []string) (*codeResult, error) {
+		n, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %s", fields[0])
+		}
+		return &codeResult{N: 2 * n, Str: strings.Join(fields[1:], "+")}, nil
+	}
+	format := func(res *codeResult) []string {
+		f := []string{strconv.FormatInt(res.N, 10)}
+		if res.Str != "" {
+			f = append(f, res.Str)
+		}
+		return f
+	}
+
+	cases := []struct {
+		name     string
+		in       string
+		opts     streamOpts
+		json     bool
+		nfields  int
+		wantOut  string
+		wantErrw string
+		wantErr  string
+	}{{
+		name:    "text",
+		in:      "1\n2\n\n  3  \n",
+		nfields: 1,
+		wantOut: "2\n4\n6\n",
+	}, {
+		name:    "text_extra_fields",
+		in:      "1 a b\n2\n",
+		nfields: 1,
+		wantOut: "2\n4\n",
+	}, {
+		name:    "text_keep",
+		in:      "1 a b\n2\n",
+		opts:    streamOpts{Keep: true},
+		nfields: 1,
+		wantOut: "2 a b\n4\n",
+	}, {
+		name:    "text_two_fields",
+		in:      "1 x\n2 y z\n",
+		opts:    streamOpts{Keep: true},
+		nfields: 2,
+		wantOut: "2 x\n4 y z\n",
+	}, {
+		name:    "csv",
+		in:      "1\n2\n",
+		opts:    streamOpts{CSV: true},
+		nfields: 1,
+		wantOut: "2\n4\n",
+	}, {
+		name:    "csv_keep",
+		in:      "1,x\n2,\"y, z\"\n",
+		opts:    streamOpts{CSV: true, Keep: true},
+		nfields: 1,
+		wantOut: "2,x\n4,\"y, z\"\n",
+	}, {
+		name:     "text_errors",
+		in:       "1\nbad\n3\n",
+		nfields:  1,
+		wantOut:  "2\n6\n",
+		wantErrw: "line 2: bad number bad\n",
+		wantErr:  "1 of 3 records failed",
+	}, {
+		name:     "text_too_few_fields",
+		in:       "1 x\n2\n\n3 z\n",
+		nfields:  2,
+		wantOut:  "2 x\n6 z\n",
+		wantErrw: "line 2: got 1 field(s), want 2\n",
+		wantErr:  "1 of 3 records failed",
+	}, {
+		name:     "csv_errors",
+		in:       "1,\"a\nb\"\nbad\n3,c\n",
+		opts:     streamOpts{CSV: true},
+		nfields:  1,
+		wantOut:  "2\n6\n",
+		wantErrw: "line 3: bad number bad\n",
+		wantErr:  "1 of 3 records failed",
+	}, {
+		name:    "csv_malformed",
+		in:      "1\n\"2\n",
+		opts:    streamOpts{CSV: true},
+		nfields: 1,
+		wantOut: "2\n",
+		wantErr: "reading line 2",
+	}, {
+		name:    "json",
+		in:      "1 x\nbad\n",
+		json:    true,
+		opts:    streamOpts{Keep: true},
+		nfields: 1,
+		wantOut: `{"key_id":0,"type":0,"n":2,"str":"","base":0,"version":0,"extra":["x"]}` + "\n" +
+			`{"error":"bad number bad","line":2}` + "\n",
+		wantErr: "1 of 2 records failed",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var out, errw bytes.Buffer
+			err := stream(strings.NewReader(c.in), output{json: c.json, w: &out}, &errw, c.opts, c.nfields, fn, format)
+			if c.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("got error %v, want %q", err, c.wantErr)
+			}
+			if got := out.String(); got != c.wantOut {
+				t.Errorf("got output %q, want %q", got, c.wantOut)
+			}
+			if got := errw.String(); got != c.wantErrw {
+				t.Errorf("got error output %q, want %q", got, c.wantErrw)
+			}
+		})
+	}
+}
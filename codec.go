@@ -0,0 +1,206 @@
+package encid
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Codec encodes and decodes numbers using a fixed set of options,
+// chosen once when it is created with [NewCodec].
+// A Codec is safe for concurrent use
+// if its keystore and randomness source are.
+type Codec struct {
+	c *config
+}
+
+// NewCodec creates a new [Codec] with the given options.
+// A keystore must be supplied with [WithKeyStore].
+func NewCodec(opts ...Option) (*Codec, error) {
+	c := newConfig(nil, opts)
+	if c.ks == nil {
+		return nil, fmt.Errorf("no keystore")
+	}
+	if c.version < 0 || c.version > maxVersion {
+		return nil, fmt.Errorf("unsupported format version %d", c.version)
+	}
+	if err := c.checkGroups(); err != nil {
+		return nil, err
+	}
+	return &Codec{c: c}, nil
+}
+
+// maxVersion is the highest encoding version that this package understands.
+const maxVersion = 2
+
+// WithKeyStore is an option that sets the keystore for a [Codec].
+func WithKeyStore(ks KeyStore) Option {
+	return func(c *config) {
+		c.ks = ks
+	}
+}
+
+// WithVersion is an option that sets the encoding version ("format version"),
+// overriding the version reported by the keystore and its keys.
+// See [Versioner] and [KeyVersioner].
+// Decoding must use the same version as encoding.
+func WithVersion(version int) Option {
+	return func(c *config) {
+		c.version = version
+	}
+}
+
+// WithRand is an option that sets the source of randomness used in encoding.
+// The default is [crypto/rand.Reader].
+// Only version-1 encoding uses randomness.
+func WithRand(r io.Reader) Option {
+	return func(c *config) {
+		c.rand = r
+	}
+}
+
+// Encode encodes a number n using a key of the given type.
+// It returns the ID of the key used and the encrypted string.
+// See [Encode].
+func (c *Codec) Encode(ctx context.Context, typ int, n int64) (int64, string, error) {
+	return c.c.encode(ctx, typ, n)
+}
+
+// Decode decodes a keyID/string pair produced by [Codec.Encode].
+// It returns the type of the key that was used and the decrypted number.
+// See [Decode].
+func (c *Codec) Decode(ctx context.Context, keyID int64, inp string) (int, int64, error) {
+	return c.c.decode(ctx, keyID, inp)
+}
+
+// EncodeToken encodes a number n as a token using a key of the given type.
+// See [EncodeToken].
+func (c *Codec) EncodeToken(ctx context.Context, typ int, n int64) (string, error) {
+	return c.c.encodeToken(ctx, typ, n)
+}
+
+// DecodeToken decodes a token produced by [Codec.EncodeToken].
+// It returns the type of the key that was used and the decrypted number.
+// See [DecodeToken].
+func (c *Codec) DecodeToken(ctx context.Context, tok string) (int, int64, error) {
+	return c.c.decodeToken(ctx, tok)
+}
+
+func (c *config) encode(ctx context.Context, typ int, n int64) (int64, string, error) {
+	keyID, block, err := encodeBlock(ctx, c.ks, typ, n, c.rand, c.blockOpts)
+	if err != nil {
+		return 0, "", err
+	}
+	str, err := convertBlock(block, c.base)
+	if err != nil {
+		return 0, "", err
+	}
+	str, err = c.finish(typ, str, len(block))
+	return keyID, str, err
+}
+
+func (c *config) decode(ctx context.Context, keyID int64, inp string) (int, int64, error) {
+	prefixTyp, inp, hasPrefix := c.splitPrefix(inp)
+	inp, err := c.stripCheck(c.normalize(inp))
+	if err != nil {
+		return 0, 0, err
+	}
+	typ, n, err := decode(ctx, c.ks, keyID, inp, c.base, c.blockOpts)
+	if err != nil {
+		return 0, 0, err
+	}
+	return typ, n, c.check(typ, prefixTyp, hasPrefix)
+}
+
+func (c *config) encodeToken(ctx context.Context, typ int, n int64) (string, error) {
+	keyID, block, err := encodeBlock(ctx, c.ks, typ, n, c.rand, c.blockOpts)
+	if err != nil {
+		return "", err
+	}
+	bin, err := tokenBytes(keyID, block)
+	if err != nil {
+		return "", err
+	}
+	tok, err := convertBlock(bin, c.base)
+	if err != nil {
+		return "", err
+	}
+	return c.finish(typ, tok, len(bin))
+}
+
+func (c *config) decodeToken(ctx context.Context, tok string) (int, int64, error) {
+	prefixTyp, tok, hasPrefix := c.splitPrefix(tok)
+	tok, err := c.stripCheck(c.normalize(tok))
+	if err != nil {
+		return 0, 0, err
+	}
+	typ, n, err := decodeToken(ctx, c.ks, tok, c.base, c.blockOpts)
+	if err != nil {
+		return 0, 0, err
+	}
+	return typ, n, c.check(typ, prefixTyp, hasPrefix)
+}
+
+// check checks the type of the key used in decoding
+// against the prefix found by splitPrefix, if any,
+// and against the types given with WithTypes.
+func (c *config) check(typ, prefixTyp int, hasPrefix bool) error {
+	if err := c.checkPrefix(typ, prefixTyp, hasPrefix); err != nil {
+		return err
+	}
+	return c.checkType(typ)
+}
+
+// finish pads s, which encodes nbytes bytes, if that option is set,
+// adds a check character, if that option is set,
+// splits it into groups, if that option is set,
+// and adds the prefix for typ, if there is one.
+func (c *config) finish(typ int, s string, nbytes int) (string, error) {
+	if err := c.checkGroups(); err != nil {
+		return "", err
+	}
+	s, err := c.pad(s, nbytes)
+	if err != nil {
+		return "", err
+	}
+	if s, err = c.addCheck(s); err != nil {
+		return "", err
+	}
+	return c.prefixes[typ] + c.group(s), nil
+}
+
+// versionKeyStore is a KeyStore whose keys all have the same encoding version.
+// See WithVersion.
+type versionKeyStore struct {
+	KeyStore
+	version int
+}
+
+var (
+	_ Versioner         = versionKeyStore{}
+	_ KeyVersioner      = versionKeyStore{}
+	_ KeyEncoder        = versionKeyStore{}
+	_ VersionedKeyStore = versionKeyStore{}
+)
+
+func (v versionKeyStore) Version() int {
+	return v.version
+}
+
+func (v versionKeyStore) KeyVersion(context.Context, int64) (int, error) {
+	return v.version, nil
+}
+
+func (v versionKeyStore) VersionedDecoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), int, error) {
+	typ, dec, err := v.DecoderByID(ctx, id)
+	return typ, dec, v.version, err
+}
+
+func (v versionKeyStore) VersionedEncoderByType(ctx context.Context, typ int) (int64, func(dst, src []byte), int, error) {
+	id, enc, err := v.EncoderByType(ctx, typ)
+	return id, enc, v.version, err
+}
+
+func (v versionKeyStore) EncoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), error) {
+	return encoderByID(ctx, v.KeyStore, id)
+}
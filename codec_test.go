@@ -0,0 +1,87 @@
+package encid_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bobg/basexx/v2"
+
+	"github.com/bobg/encid"
+	"github.com/bobg/encid/testutil"
+)
+
+func TestCodec(t *testing.T) {
+	ctx := context.Background()
+
+	if _, err := encid.NewCodec(); err == nil {
+		t.Error("creating codec without keystore: got nil error")
+	}
+
+	ks := testutil.KeyStore{NumTypes: 100, Ver: 1}
+
+	if _, err := encid.NewCodec(encid.WithKeyStore(ks), encid.WithVersion(99)); err == nil {
+		t.Error("creating codec with version 99: got nil error")
+	}
+
+	t.Run("rand", func(t *testing.T) {
+		var zeroBytes zeroByteSource
+
+		codec, err := encid.NewCodec(encid.WithKeyStore(ks), encid.WithRand(zeroBytes))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, got, err := codec.Encode(ctx, 1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, want, err := encid.PrivateEncode(ctx, ks, 1, 1, zeroBytes, basexx.Base30)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	})
+
+	t.Run("version", func(t *testing.T) {
+		codec, err := encid.NewCodec(encid.WithKeyStore(ks), encid.WithVersion(2), encid.WithBase(encid.Base58))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keyID, str, err := codec.Encode(ctx, 1, 17)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A version-2 string decodes with the same options...
+		typ, n, err := encid.Decode(ctx, ks, keyID, str, encid.WithVersion(2), encid.WithBase(encid.Base58))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != 1 || n != 17 {
+			t.Errorf("got (%d, %d), want (1, 17)", typ, n)
+		}
+
+		// ...and the same as with an authenticated keystore.
+		if _, n, err = encid.Decode(ctx, encid.Authenticated(ks), keyID, str, encid.WithBase(encid.Base58)); err != nil {
+			t.Fatal(err)
+		}
+		if n != 17 {
+			t.Errorf("got %d, want 17", n)
+		}
+
+		tok, err := codec.EncodeToken(ctx, 2, 18)
+		if err != nil {
+			t.Fatal(err)
+		}
+		typ, n, err = codec.DecodeToken(ctx, tok)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != 2 || n != 18 {
+			t.Errorf("got (%d, %d), want (2, 18)", typ, n)
+		}
+	})
+}
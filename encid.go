@@ -8,7 +8,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"strings"
 
 	"github.com/bobg/basexx/v2"
 	"github.com/bobg/errors"
@@ -17,6 +16,7 @@ import (
 // KeyStore is an object that stores encryption keys.
 // Each key is 16, 24, or 32 bytes long,
 // and has an associated "type" (an int) and a unique key ID (an int64).
+// Key IDs must be positive to be embedded in tokens (see [EncodeToken]).
 // These keys can be used to encrypt other int64s,
 // and to decrypt the resulting strings.
 // See Encode and Decode.
@@ -25,12 +25,22 @@ import (
 // you should also implement the [Versioner] interface,
 // and return a value of 2 or greater from the Version method.
 // A KeyStore that isn't also a Versioner is assumed to be at version 1.
+//
+// Better still, implement the [KeyVersioner] interface,
+// reporting a version for each key.
+// This allows a KeyStore to mix keys of different versions
+// so that it can be upgraded gradually.
+// A KeyStore that must query a database for each key
+// can report the versions in the same queries
+// by implementing [VersionedKeyStore] too.
 type KeyStore interface {
 	// DecoderByID looks up a key in the store by its ID.
 	// It returns the key's type and a function for decrypting a data block using the key.
 	// The slice arguments to the decryption function must overlap entirely or not at all.
 	// If no key with the given ID is found,
 	// ErrNotFound is returned.
+	// If the key has been revoked,
+	// ErrRevoked is returned.
 	DecoderByID(context.Context, int64) (int, func(dst, src []byte), error)
 
 	// EncoderByType looks up a key in the store by its type.
@@ -38,6 +48,8 @@ type KeyStore interface {
 	// The slice arguments to the encryption function must overlap entirely or not at all.
 	// In case there are multiple keys of the given type,
 	// it is up to the implementation to choose one and return it.
+	// Implementations supporting key rotation should skip keys that are not active
+	// (see [KeyState]).
 	// If no key with the given type is found,
 	// ErrNotFound is returned.
 	EncoderByType(context.Context, int) (int64, func(dst, src []byte), error)
@@ -51,6 +63,7 @@ type KeyStore interface {
 // See https://github.com/bobg/encid/issues/5.
 //
 // If a KeyStore does not implement Versioner, it is assumed to be at version 1.
+// A version-1 KeyStore can be made to report version 2 with [Authenticated].
 //
 // Version 2 encoded IDs are not compatible with version 1 IDs;
 // you can't decode a v2 ID using a v1 KeyStore and vice versa.
@@ -58,21 +71,162 @@ type Versioner interface {
 	Version() int
 }
 
+// KeyVersioner is an optional interface that KeyStores may implement.
+// It reports the version of the encoding to use with a specific key.
+// This permits a single KeyStore to contain keys with different versions,
+// e.g. when migrating from version 1 to version 2
+// (see [Versioner]):
+// strings encoded with version-1 keys continue to decode,
+// while new keys use version 2.
+//
+// When a KeyStore implements KeyVersioner,
+// its KeyVersion method takes precedence over any Version method.
+type KeyVersioner interface {
+	// KeyVersion reports the encoding version of the key with the given ID.
+	// If no key with the given ID is found,
+	// ErrNotFound is returned.
+	KeyVersion(context.Context, int64) (int, error)
+}
+
+// VersionedKeyStore is an optional interface that KeyStores may implement
+// to report each key's encoding version
+// in the same lookup that finds the key,
+// rather than in a separate call to KeyVersion
+// (see [KeyVersioner]).
+// For a keystore backed by a database,
+// this saves a query for each encoded or decoded string.
+//
+// When a KeyStore implements VersionedKeyStore,
+// its methods take precedence over any KeyVersion or Version method.
+type VersionedKeyStore interface {
+	// VersionedDecoderByID is like KeyStore.DecoderByID
+	// but also returns the key's encoding version.
+	VersionedDecoderByID(context.Context, int64) (int, func(dst, src []byte), int, error)
+
+	// VersionedEncoderByType is like KeyStore.EncoderByType
+	// but also returns the key's encoding version.
+	VersionedEncoderByType(context.Context, int) (int64, func(dst, src []byte), int, error)
+}
+
 // ErrNotFound is the type of error produced when KeyStore methods find no key.
 var ErrNotFound = errors.New("not found")
 
+// ErrRevoked is the type of error produced when KeyStore methods find a key that has been revoked.
+// See [KeyRevoked].
+var ErrRevoked = errors.New("revoked")
+
+// ErrInvalidToken is the type of error produced when decoding an input
+// that was not produced by encoding,
+// e.g. because it was mistyped or forged.
+//
+// Only version 2 and later encodings reliably detect invalid inputs.
+// Almost any input will decode to some number using a version 1 keystore.
+// See [Authenticated] for a way to get version 2 encodings from a version 1 keystore.
+var ErrInvalidToken = errors.New("invalid token")
+
+// ErrWrongType is the type of error produced when decoding an input
+// whose key has a different type than expected,
+// e.g. because its prefix belongs to another type.
+// When the expected types are known,
+// the error is a [*WrongTypeError] giving the details.
+// See [WithPrefix] and [WithTypes].
+var ErrWrongType = errors.New("wrong type")
+
+// KeyState is the lifecycle state of a key,
+// for KeyStores that support key rotation.
+type KeyState int
+
+const (
+	// KeyActive is the state of a key that may be used for encoding and decoding.
+	KeyActive KeyState = iota
+
+	// KeyDecodeOnly is the state of a key that has been rotated out or retired.
+	// It may be used for decoding but not for encoding.
+	// KeyStore.EncoderByType skips such keys.
+	KeyDecodeOnly
+
+	// KeyRevoked is the state of a key that may not be used at all.
+	// KeyStore.DecoderByID returns ErrRevoked for such keys.
+	KeyRevoked
+)
+
+func (s KeyState) String() string {
+	switch s {
+	case KeyActive:
+		return "active"
+	case KeyDecodeOnly:
+		return "decode-only"
+	case KeyRevoked:
+		return "revoked"
+	default:
+		return fmt.Sprintf("KeyState(%d)", int(s))
+	}
+}
+
+// version reports the encoding version for ks.
+// See [Versioner].
+func version(ks KeyStore) int {
+	if v, ok := ks.(Versioner); ok {
+		return v.Version()
+	}
+	return 1
+}
+
+// keyVersion reports the encoding version for the key in ks with the given ID.
+// See [KeyVersioner].
+func keyVersion(ctx context.Context, ks KeyStore, keyID int64) (int, error) {
+	if kv, ok := ks.(KeyVersioner); ok {
+		v, err := kv.KeyVersion(ctx, keyID)
+		return v, errors.Wrapf(err, "getting version of key %d", keyID)
+	}
+	return version(ks), nil
+}
+
+// decoderByID looks up the key in ks with the given ID
+// and returns its type, its decryption function, and its encoding version,
+// in a single lookup if ks is a [VersionedKeyStore].
+func decoderByID(ctx context.Context, ks KeyStore, keyID int64) (int, func(dst, src []byte), int, error) {
+	if vks, ok := ks.(VersionedKeyStore); ok {
+		return vks.VersionedDecoderByID(ctx, keyID)
+	}
+
+	ver, err := keyVersion(ctx, ks, keyID)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	typ, dec, err := ks.DecoderByID(ctx, keyID)
+	return typ, dec, ver, err
+}
+
+// encoderByType looks up a key in ks with the given type
+// and returns its ID, its encryption function, and its encoding version,
+// in a single lookup if ks is a [VersionedKeyStore].
+func encoderByType(ctx context.Context, ks KeyStore, typ int) (int64, func(dst, src []byte), int, error) {
+	if vks, ok := ks.(VersionedKeyStore); ok {
+		return vks.VersionedEncoderByType(ctx, typ)
+	}
+
+	keyID, enc, err := ks.EncoderByType(ctx, typ)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	ver, err := keyVersion(ctx, ks, keyID)
+	return keyID, enc, ver, err
+}
+
 // Encode encodes a number n using a key of the given type from the given keystore.
 // The result is the ID of the key used, followed by the encrypted string.
 // The encrypted string is expressed in base 30,
 // which uses digits 0-9, then lower-case bcdfghjkmnpqrstvwxyz.
 // It excludes vowels (to avoid inadvertently spelling naughty words) and lowercase "L".
+// Use [WithBase] to choose a different base.
 //
 // If the keystore is also a [Versioner] that reports a version of 2 or greater,
 // the resulting string will use a different encoding than in earlier versions
 // and can be decoded only with a keystore that also reports a version of 2 or greater.
 // See https://github.com/bobg/encid/issues/5.
-func Encode(ctx context.Context, ks KeyStore, typ int, n int64) (int64, string, error) {
-	return encode(ctx, ks, typ, n, rand.Reader, basexx.Base30)
+func Encode(ctx context.Context, ks KeyStore, typ int, n int64, opts ...Option) (int64, string, error) {
+	return newConfig(ks, opts).encode(ctx, typ, n)
 }
 
 // Encode50 is the same as Encode but it expresses the encrypted string in base 50,
@@ -87,47 +241,85 @@ func Encode50(ctx context.Context, ks KeyStore, typ int, n int64) (int64, string
 }
 
 func encode(ctx context.Context, ks KeyStore, typ int, n int64, randBytes io.Reader, base basexx.Base) (int64, string, error) {
-	keyID, enc, err := ks.EncoderByType(ctx, typ)
+	keyID, block, err := encodeBlock(ctx, ks, typ, n, randBytes, blockOpts{})
 	if err != nil {
-		return 0, "", errors.Wrapf(err, "getting key with type %d from keystore", typ)
+		return 0, "", err
+	}
+	result, err := convertBlock(block, base)
+	return keyID, result, err
+}
+
+// convertBlock expresses an encrypted block in the given base.
+func convertBlock(block []byte, base basexx.Base) (string, error) {
+	result, err := basexx.Convert(string(block), basexx.Binary, base)
+	return result, errors.Wrapf(err, "converting %x to base%d", block, base.N())
+}
+
+// encodeBlock produces the encrypted block for n
+// using a key of the given type from the given keystore.
+// It returns the ID of the key used and the block,
+// which is [aes.BlockSize] bytes long,
+// or 8 bytes long in the short format (see [WithShortFormat]).
+func encodeBlock(ctx context.Context, ks KeyStore, typ int, n int64, randBytes io.Reader, bo blockOpts) (int64, []byte, error) {
+	if bo.short && bo.needsV2() {
+		return 0, nil, fmt.Errorf("expiry times and scopes are not supported in the short format")
 	}
 
-	var buf [aes.BlockSize]byte
+	if bo.short {
+		keyID, enc, err := ks.EncoderByType(ctx, typ)
+		if err != nil {
+			return 0, nil, errors.Wrapf(err, "getting key with type %d from keystore", typ)
+		}
+		var buf [shortBlockSize]byte
+		binary.BigEndian.PutUint64(buf[:], uint64(n))
+		shortEncrypt(enc, &buf)
+		return keyID, buf[:], nil
+	}
+
+	keyID, enc, ver, err := encoderByType(ctx, ks, typ)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "getting key with type %d from keystore", typ)
+	}
 
-	versioner, isV2 := ks.(Versioner)
-	isV2 = isV2 && versioner.Version() >= 2
+	if bo.needsV2() && ver < 2 {
+		return 0, nil, fmt.Errorf("expiry times and scopes require a version-2 key, but key %d has version %d", keyID, ver)
+	}
 
-	if isV2 {
+	var buf [aes.BlockSize]byte
+
+	if ver >= 2 {
 		buf[0] = 2 // Version byte.
 		binary.LittleEndian.PutUint64(buf[1:], uint64(n))
+		if err := bo.putExpiry(&buf); err != nil {
+			return 0, nil, err
+		}
+		bo.applyScope(&buf)
 	} else {
 		nbytes := binary.PutVarint(buf[:], n)
 		_, err = io.ReadFull(randBytes, buf[nbytes:])
 		if err != nil {
-			return 0, "", errors.Wrap(err, "padding cipher block with random bytes")
+			return 0, nil, errors.Wrap(err, "padding cipher block with random bytes")
 		}
 	}
 
 	enc(buf[:], buf[:])
+	bo.applyScope(&buf)
 
-	result, err := basexx.Convert(string(buf[:]), basexx.Binary, base)
-	if err != nil {
-		return 0, "", errors.Wrapf(err, "converting %x to base%d", buf[:], base.N())
-	}
-
-	return keyID, result, nil
+	return keyID, buf[:], nil
 }
 
 // Decode decodes a keyID/string pair produced by Encode.
 // It produces the type of the key that was used, and the bare int64 value that was encrypted.
-// As a convenience, it maps the input string to all lowercase before decoding.
+// As a convenience, it maps the input string to all lowercase before decoding
+// (unless a different base is chosen with [WithBase]).
+// The options must match those given to Encode.
 //
 // If the keystore is also a [Versioner] that reports a version of 2 or greater,
 // the input string must use version-2 encoding
 // (i.e., it must have been produced with a keystore that also reports a version of 2 or greater).
 // See https://github.com/bobg/encid/issues/5.
-func Decode(ctx context.Context, ks KeyStore, keyID int64, inp string) (int, int64, error) {
-	return decode(ctx, ks, keyID, strings.ToLower(inp), basexx.Base30)
+func Decode(ctx context.Context, ks KeyStore, keyID int64, inp string, opts ...Option) (int, int64, error) {
+	return newConfig(ks, opts).decode(ctx, keyID, inp)
 }
 
 // Decode50 decodes a keyID/string pair produced by Encode50.
@@ -140,41 +332,86 @@ func Decode(ctx context.Context, ks KeyStore, keyID int64, inp string) (int, int
 // (i.e., it must have been produced with a keystore that also reports a version of 2 or greater).
 // See https://github.com/bobg/encid/issues/5.
 func Decode50(ctx context.Context, ks KeyStore, keyID int64, inp string) (int, int64, error) {
-	return decode(ctx, ks, keyID, inp, basexx.Base50)
+	return decode(ctx, ks, keyID, inp, basexx.Base50, blockOpts{})
 }
 
-func decode(ctx context.Context, ks KeyStore, keyID int64, inp string, base basexx.Base) (int, int64, error) {
-	typ, dec, err := ks.DecoderByID(ctx, keyID)
+func decode(ctx context.Context, ks KeyStore, keyID int64, inp string, base basexx.Base, bo blockOpts) (int, int64, error) {
+	bin, err := convertInput(inp, base)
 	if err != nil {
-		return 0, 0, errors.Wrapf(err, "getting key with ID %d", keyID)
+		return 0, 0, err
 	}
 
-	bin, err := basexx.Convert(inp, base, basexx.Binary)
+	return decodeBlock(ctx, ks, keyID, bin, bo)
+}
+
+// convertInput converts inp from the given base to binary,
+// removing any leading zeroes.
+// An input that contains characters that are not digits of the base
+// must have been mistyped or forged,
+// so the error wraps [ErrInvalidToken].
+func convertInput(inp string, base basexx.Base) ([]byte, error) {
+	bin, err := basexx.Convert(trimZeros(inp, base), base, basexx.Binary)
 	if err != nil {
-		return 0, 0, errors.Wrapf(err, "converting %s from base%d", inp, base.N())
+		return nil, errors.Wrapf(err, "converting %s from base%d (%w)", inp, base.N(), ErrInvalidToken)
 	}
+	return []byte(bin), nil
+}
 
+// decodeBlock decrypts bin,
+// an encrypted block with any leading zero bytes removed,
+// using the key with the given ID from the given keystore.
+// It returns the type of the key and the decrypted number.
+//
+// With the short-format option,
+// a block of up to 8 bytes is taken to be in the short format.
+// (A block in the long format is that short
+// with negligible probability.)
+func decodeBlock(ctx context.Context, ks KeyStore, keyID int64, bin []byte, bo blockOpts) (int, int64, error) {
 	if len(bin) > aes.BlockSize {
-		return 0, 0, fmt.Errorf("input string too long (%d bytes)", len(bin))
+		return 0, 0, errors.Wrapf(ErrInvalidToken, "input string too long (%d bytes)", len(bin))
+	}
+
+	if bo.short && len(bin) <= shortBlockSize {
+		return decodeShortBlock(ctx, ks, keyID, bin, bo)
+	}
+
+	typ, dec, ver, err := decoderByID(ctx, ks, keyID)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "getting key with ID %d", keyID)
+	}
+
+	if len(bo.scope) > 0 && ver < 2 {
+		return 0, 0, errors.Wrapf(ErrInvalidToken, "key %d has version %d, which does not support scopes", keyID, ver)
 	}
 
 	var decryptBuf [aes.BlockSize]byte
 	copy(decryptBuf[aes.BlockSize-len(bin):], bin)
+	bo.applyScope(&decryptBuf)
 	dec(decryptBuf[:], decryptBuf[:])
+	bo.applyScope(&decryptBuf)
 
-	if v, ok := ks.(Versioner); ok && v.Version() >= 2 {
+	if ver >= 2 {
 		// For version 2 keystores and later,
 		// check the version byte,
 		// and that the buffer is zero-padded.
 		// See https://github.com/bobg/encid/issues/5.
+		// Blocks with an expiry time have a different version byte,
+		// and less zero padding.
 
-		if decryptBuf[0] != 2 {
-			return 0, 0, fmt.Errorf("unexpected version byte %d", decryptBuf[0])
-		}
+		switch decryptBuf[0] {
+		case 2:
+			var zeroes [aes.BlockSize - 9]byte
+			if !bytes.Equal(decryptBuf[9:], zeroes[:]) {
+				return 0, 0, errors.Wrap(ErrInvalidToken, "zero-padding check failed")
+			}
+
+		case expiringBlock:
+			if err := bo.checkExpiry(&decryptBuf); err != nil {
+				return 0, 0, err
+			}
 
-		var zeroes [aes.BlockSize - 9]byte
-		if !bytes.Equal(decryptBuf[9:], zeroes[:]) {
-			return 0, 0, fmt.Errorf("zero-padding check failed")
+		default:
+			return 0, 0, errors.Wrapf(ErrInvalidToken, "unexpected version byte %d", decryptBuf[0])
 		}
 
 		n := int64(binary.LittleEndian.Uint64(decryptBuf[1:]))
@@ -184,7 +421,7 @@ func decode(ctx context.Context, ks KeyStore, keyID int64, inp string, base base
 
 	n, x := binary.Varint(decryptBuf[:])
 	if x <= 0 {
-		return 0, 0, fmt.Errorf("decoding error")
+		return 0, 0, errors.Wrap(ErrInvalidToken, "decoding error")
 	}
 	return typ, n, nil
 }
@@ -2,9 +2,14 @@ package encid_test
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"math"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bobg/basexx/v2"
 
@@ -102,7 +107,7 @@ func TestDecode(t *testing.T) {
 				base = basexx.Base30
 			}
 
-			gotType, gotN, err := encid.PrivateDecode(ctx, ks, c.inpKeyID, c.inpStr, base)
+			gotType, gotN, err := encid.PrivateDecode(ctx, ks, c.inpKeyID, c.inpStr, base, encid.PrivateBlockOpts{})
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -150,5 +155,988 @@ func TestErrs(t *testing.T) {
 		if !errors.Is(err, basexx.ErrInvalid) {
 			t.Errorf("got error %v, want %v", err, basexx.ErrInvalid)
 		}
+		if !errors.Is(err, encid.ErrInvalidToken) {
+			t.Errorf("got error %v, want %v", err, encid.ErrInvalidToken)
+		}
+	})
+
+	t.Run("BadDigit", func(t *testing.T) {
+		// A mistyped vowel, which is not a digit of base 30.
+		_, _, err := encid.Decode(ctx, ks, 1, "4gsb6bwnsvzdr9sg1wb9f748pa")
+		if !errors.Is(err, encid.ErrInvalidToken) {
+			t.Errorf("got error %v, want %v", err, encid.ErrInvalidToken)
+		}
+		_, _, err = encid.DecodeToken(ctx, ks, "1gnmj2y5bmpbh6db4v83f2mwjya")
+		if !errors.Is(err, encid.ErrInvalidToken) {
+			t.Errorf("decoding token: got error %v, want %v", err, encid.ErrInvalidToken)
+		}
+	})
+}
+
+func TestToken(t *testing.T) {
+	cases := []struct {
+		typ     int
+		n       int64
+		version int
+		base    basexx.Base
+		want    string
+	}{
+		{typ: 1, n: 1, version: 1, want: "1gnmj2y5bmpbh6db4v83f2mwjyk"},
+		{typ: 2, n: 1, version: 1, want: "3ynwjyddrnn49k8cgn9n4kd8nxc"},
+		{typ: 1, n: 1, version: 1, want: "hSrCmFcBvZYFZP8ygYD78V7", base: basexx.Base50},
+		{typ: 1, n: 1, version: 2, want: "1tvjfckt8qksf719m5bm31ry1r8"},
+		{typ: 2, n: 1, version: 2, want: "3q8qv0x2qzmk1zw0bpvdkj07jk0"},
+		{typ: 1, n: 1, version: 2, want: "ntDHVyf3qnt38B9Tnt2n11m", base: basexx.Base50},
+	}
+
+	var (
+		zeroBytes zeroByteSource
+		ctx       = context.Background()
+	)
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case_%02d", i+1), func(t *testing.T) {
+			ks := testutil.KeyStore{NumTypes: 100, Ver: c.version}
+
+			base := c.base
+			if base == nil {
+				base = basexx.Base30
+			}
+
+			got, err := encid.PrivateEncodeToken(ctx, ks, c.typ, c.n, zeroBytes, base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("got %s, want %s", got, c.want)
+			}
+
+			var (
+				gotType int
+				gotN    int64
+			)
+			if c.base != nil {
+				gotType, gotN, err = encid.DecodeToken50(ctx, ks, got)
+			} else {
+				gotType, gotN, err = encid.DecodeToken(ctx, ks, got)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotType != c.typ {
+				t.Errorf("got type %d, want %d", gotType, c.typ)
+			}
+			if gotN != c.n {
+				t.Errorf("got N=%d, want %d", gotN, c.n)
+			}
+		})
+	}
+}
+
+// versionedKeyStore is a countingKeyStore that is also an encid.VersionedKeyStore.
+type versionedKeyStore struct {
+	*countingKeyStore
+	versioned int
+}
+
+func (v *versionedKeyStore) VersionedDecoderByID(ctx context.Context, keyID int64) (int, func(dst, src []byte), int, error) {
+	v.versioned++
+	typ, dec, err := v.KeyStore.DecoderByID(ctx, keyID)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	ver, err := v.KeyStore.KeyVersion(ctx, keyID)
+	return typ, dec, ver, err
+}
+
+func (v *versionedKeyStore) VersionedEncoderByType(ctx context.Context, typ int) (int64, func(dst, src []byte), int, error) {
+	v.versioned++
+	keyID, enc, err := v.KeyStore.EncoderByType(ctx, typ)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	ver, err := v.KeyStore.KeyVersion(ctx, keyID)
+	return keyID, enc, ver, err
+}
+
+func TestVersionedKeyStore(t *testing.T) {
+	var (
+		ctx = context.Background()
+		ks  = &versionedKeyStore{countingKeyStore: &countingKeyStore{KeyStore: testutil.KeyStore{NumTypes: 100, Ver: 2, KeyVers: map[int64]int{3: 1}}}}
+	)
+
+	// Each encoding and decoding gets the key and its version in one lookup,
+	// including through Authenticated.
+	for _, k := range []encid.KeyStore{ks, encid.Authenticated(ks)} {
+		for _, typ := range []int{3, 4} {
+			before := ks.versioned
+			keyID, str, err := encid.Encode(ctx, k, typ, 17)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, n, err := encid.Decode(ctx, k, keyID, str); err != nil || n != 17 {
+				t.Errorf("decoding %s: got (%d, %v), want 17", str, n, err)
+			}
+			if got := ks.versioned - before; got != 2 {
+				t.Errorf("type %d: got %d versioned lookups, want 2", typ, got)
+			}
+		}
+	}
+	if ks.decoders != 0 || ks.encoders != 0 || ks.versions != 0 {
+		t.Errorf("got (decoders, encoders, versions) = (%d, %d, %d), want (0, 0, 0)", ks.decoders, ks.encoders, ks.versions)
+	}
+
+	// The version-1 key produced a version-1 string.
+	keyID, str, err := encid.Encode(ctx, ks, 3, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := encid.Decode(ctx, encid.Authenticated(ks), keyID, str); !errors.Is(err, encid.ErrInvalidToken) {
+		t.Errorf("decoding version-1 string with Authenticated: got error %v, want %v", err, encid.ErrInvalidToken)
+	}
+}
+
+func TestAuthenticated(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		v1ks = testutil.KeyStore{NumTypes: 100, Ver: 1}
+		ks   = encid.Authenticated(v1ks)
+	)
+
+	keyID, str, err := encid.Encode(ctx, ks, 1, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	typ, n, err := encid.Decode(ctx, ks, keyID, str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != 1 || n != 17 {
+		t.Errorf("got (%d, %d), want (1, 17)", typ, n)
+	}
+
+	const forged = "4gsb6bwnsvzdr9sg1wb9f748p2"
+
+	if _, _, err := encid.Decode(ctx, v1ks, 1, forged); err != nil {
+		t.Fatalf("unauthenticated decode: %s", err)
+	}
+
+	_, _, err = encid.Decode(ctx, ks, 1, forged)
+	if !errors.Is(err, encid.ErrInvalidToken) {
+		t.Errorf("got error %v, want %v", err, encid.ErrInvalidToken)
+	}
+}
+
+func TestMixedVersions(t *testing.T) {
+	var (
+		zeroBytes zeroByteSource
+		ctx       = context.Background()
+		ks        = testutil.KeyStore{NumTypes: 100, Ver: 2, KeyVers: map[int64]int{1: 1}}
+	)
+
+	cases := []struct {
+		typ  int
+		want string
+	}{
+		{typ: 1, want: "4gsb6bwnsvzdr9sg1wb9f748p1"},  // version 1
+		{typ: 2, want: "10z1f7rhb9ztx1pwz41wb76rzzy"}, // version 2
+	}
+
+	for _, c := range cases {
+		keyID, got, err := encid.PrivateEncode(ctx, ks, c.typ, 1, zeroBytes, basexx.Base30)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("type %d: got %s, want %s", c.typ, got, c.want)
+		}
+
+		typ, n, err := encid.Decode(ctx, ks, keyID, got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != c.typ || n != 1 {
+			t.Errorf("got (%d, %d), want (%d, 1)", typ, n, c.typ)
+		}
+	}
+}
+
+func TestBases(t *testing.T) {
+	var (
+		ctx = context.Background()
+		ks  = testutil.KeyStore{NumTypes: 100, Ver: 2}
+	)
+
+	bases := map[string]basexx.Base{
+		"Crockford32": encid.Crockford32,
+		"Base58":      encid.Base58,
+		"Base64URL":   encid.Base64URL,
+		"Hex":         encid.Hex,
+	}
+
+	for name, base := range bases {
+		t.Run(name, func(t *testing.T) {
+			for _, n := range []int64{0, 1, 17, -1, 1 << 62} {
+				keyID, str, err := encid.Encode(ctx, ks, 7, n, encid.WithBase(base))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i := 0; i < len(str); i++ {
+					if _, err := base.Decode(str[i]); err != nil {
+						t.Fatalf("encoding %d: %s contains invalid digit %q", n, str, str[i])
+					}
+				}
+				typ, got, err := encid.Decode(ctx, ks, keyID, str, encid.WithBase(base))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if typ != 7 || got != n {
+					t.Errorf("decoding %s: got (%d, %d), want (7, %d)", str, typ, got, n)
+				}
+
+				tok, err := encid.EncodeToken(ctx, ks, 7, n, encid.WithBase(base))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, got, err = encid.DecodeToken(ctx, ks, tok, encid.WithBase(base)); err != nil {
+					t.Fatal(err)
+				}
+				if got != n {
+					t.Errorf("decoding token %s: got %d, want %d", tok, got, n)
+				}
+			}
+		})
+	}
+
+	t.Run("case_folding", func(t *testing.T) {
+		for _, c := range []struct {
+			base  basexx.Base
+			digit byte
+			want  int64
+		}{
+			{base: encid.Crockford32, digit: 'Z', want: 31},
+			{base: encid.Crockford32, digit: 'z', want: 31},
+			{base: encid.Crockford32, digit: 'i', want: 1},
+			{base: encid.Crockford32, digit: 'L', want: 1},
+			{base: encid.Crockford32, digit: 'o', want: 0},
+			{base: encid.Hex, digit: 'F', want: 15},
+		} {
+			got, err := c.base.Decode(c.digit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("decoding %q in base %d: got %d, want %d", c.digit, c.base.N(), got, c.want)
+			}
+		}
+
+		if _, err := encid.Base58.Decode('0'); !errors.Is(err, basexx.ErrInvalid) {
+			t.Errorf("got error %v, want %v", err, basexx.ErrInvalid)
+		}
 	})
 }
+
+func TestPadding(t *testing.T) {
+	var (
+		ctx = context.Background()
+		ks  = testutil.KeyStore{NumTypes: 100, Ver: 2}
+	)
+
+	for _, base := range []basexx.Base{basexx.Base30, basexx.Base50, encid.Crockford32, encid.Base58, encid.Hex} {
+		t.Run(fmt.Sprintf("base%d", base.N()), func(t *testing.T) {
+			var (
+				wantLen    = encid.MaxLen(base, 16)
+				wantTokLen = encid.MaxLen(base, 24)
+			)
+
+			for n := int64(0); n < 200; n++ {
+				keyID, str, err := encid.Encode(ctx, ks, 1, n, encid.WithBase(base), encid.WithPadding())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(str) != wantLen {
+					t.Fatalf("got length %d for %s, want %d", len(str), str, wantLen)
+				}
+
+				_, unpadded, err := encid.Encode(ctx, ks, 1, n, encid.WithBase(base))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// Padded and unpadded strings decode with or without the option.
+				for _, s := range []string{str, unpadded} {
+					for _, opts := range [][]encid.Option{{encid.WithBase(base)}, {encid.WithBase(base), encid.WithPadding()}} {
+						_, got, err := encid.Decode(ctx, ks, keyID, s, opts...)
+						if err != nil {
+							t.Fatalf("decoding %s: %s", s, err)
+						}
+						if got != n {
+							t.Errorf("decoding %s: got %d, want %d", s, got, n)
+						}
+					}
+				}
+
+				tok, err := encid.EncodeToken(ctx, ks, 1, n, encid.WithBase(base), encid.WithPadding())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(tok) != wantTokLen {
+					t.Fatalf("got token length %d for %s, want %d", len(tok), tok, wantTokLen)
+				}
+				if _, got, err := encid.DecodeToken(ctx, ks, tok, encid.WithBase(base)); err != nil || got != n {
+					t.Errorf("decoding token %s: got (%d, %v), want %d", tok, got, err, n)
+				}
+			}
+		})
+	}
+
+	if got := encid.MaxLen(basexx.Base30, 16); got != 27 {
+		t.Errorf("got base30 max length %d, want 27", got)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	var (
+		ctx = context.Background()
+		ks  = testutil.KeyStore{NumTypes: 100, Ver: 2}
+	)
+
+	codec, err := encid.NewCodec(
+		encid.WithKeyStore(ks),
+		encid.WithPrefix(1, "usr_"),
+		encid.WithPrefix(2, "doc_"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usrID, usr, err := codec.Encode(ctx, 1, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(usr, "usr_") {
+		t.Errorf("got %s, want usr_ prefix", usr)
+	}
+
+	typ, n, err := codec.Decode(ctx, usrID, usr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != 1 || n != 17 {
+		t.Errorf("got (%d, %d), want (1, 17)", typ, n)
+	}
+
+	// Upper-case input still decodes, but the prefix is case-sensitive.
+	if _, n, err = codec.Decode(ctx, usrID, "usr_"+strings.ToUpper(usr[4:])); err != nil || n != 17 {
+		t.Errorf("decoding upper-case input: got (%d, %v), want 17", n, err)
+	}
+
+	// Wrong prefix.
+	if _, _, err := codec.Decode(ctx, usrID, "doc_"+usr[4:]); !errors.Is(err, encid.ErrWrongType) {
+		t.Errorf("decoding with wrong prefix: got error %v, want %v", err, encid.ErrWrongType)
+	}
+
+	// Missing prefix.
+	if _, _, err := codec.Decode(ctx, usrID, usr[4:]); !errors.Is(err, encid.ErrWrongType) {
+		t.Errorf("decoding without prefix: got error %v, want %v", err, encid.ErrWrongType)
+	}
+
+	// Types without a prefix are unaffected.
+	keyID, str, err := codec.Encode(ctx, 3, 18)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, n, err := encid.Decode(ctx, ks, keyID, str); err != nil || n != 18 {
+		t.Errorf("decoding unprefixed string: got (%d, %v), want 18", n, err)
+	}
+
+	// Tokens.
+	tok, err := codec.EncodeToken(ctx, 2, 19)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(tok, "doc_") {
+		t.Errorf("got %s, want doc_ prefix", tok)
+	}
+	if typ, n, err := codec.DecodeToken(ctx, tok); err != nil || typ != 2 || n != 19 {
+		t.Errorf("decoding token %s: got (%d, %d, %v), want (2, 19, nil)", tok, typ, n, err)
+	}
+	if _, _, err := codec.DecodeToken(ctx, "usr_"+tok[4:]); !errors.Is(err, encid.ErrWrongType) {
+		t.Errorf("decoding token with wrong prefix: got error %v, want %v", err, encid.ErrWrongType)
+	}
+
+	// Surrounding whitespace does not hide the prefix.
+	if _, n, err := codec.Decode(ctx, usrID, " "+usr+"\n"); err != nil || n != 17 {
+		t.Errorf("decoding with surrounding whitespace: got (%d, %v), want 17", n, err)
+	}
+	if _, n, err := codec.DecodeToken(ctx, "\t"+tok+" "); err != nil || n != 19 {
+		t.Errorf("decoding token with surrounding whitespace: got (%d, %v), want 19", n, err)
+	}
+	batch := encid.DecodeBatch(ctx, ks, []encid.Encoded{{KeyID: usrID, Str: " " + usr + "\n"}}, encid.WithPrefix(1, "usr_"))
+	if r := batch[0]; r.Err != nil || r.N != 17 {
+		t.Errorf("batch decoding with surrounding whitespace: got (%d, %v), want 17", r.N, r.Err)
+	}
+}
+
+func TestTypes(t *testing.T) {
+	var (
+		ctx = context.Background()
+		ks  = testutil.KeyStore{NumTypes: 100, Ver: 2}
+	)
+
+	keyID, str, err := encid.Encode(ctx, ks, 3, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if typ, n, err := encid.Decode(ctx, ks, keyID, str, encid.WithTypes(2, 3)); err != nil || typ != 3 || n != 17 {
+		t.Errorf("got (%d, %d, %v), want (3, 17, nil)", typ, n, err)
+	}
+
+	_, _, err = encid.Decode(ctx, ks, keyID, str, encid.WithTypes(1, 2))
+	if !errors.Is(err, encid.ErrWrongType) {
+		t.Fatalf("got error %v, want %v", err, encid.ErrWrongType)
+	}
+	var wte *encid.WrongTypeError
+	if !errors.As(err, &wte) {
+		t.Fatalf("got error of type %T, want %T", err, wte)
+	}
+	if wte.Got != 3 || !slices.Equal(wte.Want, []int{1, 2}) {
+		t.Errorf("got %+v, want Got 3, Want [1 2]", wte)
+	}
+
+	tok, err := encid.EncodeToken(ctx, ks, 3, 18)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := encid.DecodeToken(ctx, ks, tok, encid.WithTypes(1)); !errors.Is(err, encid.ErrWrongType) {
+		t.Errorf("decoding token: got error %v, want %v", err, encid.ErrWrongType)
+	}
+
+	results := encid.DecodeBatch(ctx, ks, []encid.Encoded{{KeyID: keyID, Str: str}}, encid.WithTypes(1))
+	if !errors.Is(results[0].Err, encid.ErrWrongType) {
+		t.Errorf("decoding batch: got error %v, want %v", results[0].Err, encid.ErrWrongType)
+	}
+}
+
+func TestShort(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		ks    = testutil.KeyStore{NumTypes: 100, Ver: 2}
+		short = encid.WithShortFormat()
+	)
+
+	for _, n := range []int64{0, 1, 17, -1, 1 << 40, math.MaxInt64, math.MinInt64} {
+		keyID, str, err := encid.Encode(ctx, ks, 5, n, short)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if maxlen := encid.MaxLen(basexx.Base30, 8); len(str) > maxlen {
+			t.Errorf("encoding %d: got %s (length %d), want length at most %d", n, str, len(str), maxlen)
+		}
+		if typ, got, err := encid.Decode(ctx, ks, keyID, str, short); err != nil || typ != 5 || got != n {
+			t.Errorf("decoding %s: got (%d, %d, %v), want (5, %d, nil)", str, typ, got, err, n)
+		}
+
+		tok, err := encid.EncodeToken(ctx, ks, 5, n, short)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ, got, err := encid.DecodeToken(ctx, ks, tok, short); err != nil || typ != 5 || got != n {
+			t.Errorf("decoding token %s: got (%d, %d, %v), want (5, %d, nil)", tok, typ, got, err, n)
+		}
+
+		// Long strings and tokens still decode with the option.
+		keyID, long, err := encid.Encode(ctx, ks, 4, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ, got, err := encid.Decode(ctx, ks, keyID, long, short); err != nil || typ != 4 || got != n {
+			t.Errorf("decoding long string %s: got (%d, %d, %v), want (4, %d, nil)", long, typ, got, err, n)
+		}
+		longTok, err := encid.EncodeToken(ctx, ks, 4, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(longTok) <= len(tok) {
+			t.Errorf("got long token %s no longer than short token %s", longTok, tok)
+		}
+		if typ, got, err := encid.DecodeToken(ctx, ks, longTok, short); err != nil || typ != 4 || got != n {
+			t.Errorf("decoding long token %s: got (%d, %d, %v), want (4, %d, nil)", longTok, typ, got, err, n)
+		}
+	}
+
+	// Key ID 0 cannot go in a token,
+	// where it would make a long token look short.
+	// (Type 0 in testutil.KeyStore has key ID 0.)
+	for _, opts := range [][]encid.Option{nil, {short}} {
+		if tok, err := encid.EncodeToken(ctx, ks, 0, 17, opts...); err == nil {
+			t.Errorf("got token %s with key ID 0, want error", tok)
+		}
+	}
+	keyID, block, err := encid.Encode(ctx, ks, 0, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != 0 {
+		t.Fatalf("got key ID %d, want 0", keyID)
+	}
+	if _, _, err := encid.DecodeToken(ctx, ks, block); !errors.Is(err, encid.ErrInvalidToken) {
+		t.Errorf("decoding token without key ID: got error %v, want %v", err, encid.ErrInvalidToken)
+	}
+
+	// Without the option, a short string does not decode with a version-2 key,
+	// not even with an Authenticated keystore.
+	keyID, str, err := encid.Encode(ctx, ks, 5, 17, short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := encid.Decode(ctx, ks, keyID, str); !errors.Is(err, encid.ErrInvalidToken) {
+		t.Errorf("decoding short string without the option: got error %v, want %v", err, encid.ErrInvalidToken)
+	}
+	if _, _, err := encid.Decode(ctx, encid.Authenticated(ks), keyID, str); !errors.Is(err, encid.ErrInvalidToken) {
+		t.Errorf("decoding short string with Authenticated: got error %v, want %v", err, encid.ErrInvalidToken)
+	}
+
+	codec, err := encid.NewCodec(encid.WithKeyStore(ks), short, encid.WithPadding())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID, str, err = codec.Encode(ctx, 4, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := encid.MaxLen(basexx.Base30, 8); len(str) != want {
+		t.Errorf("got padded string %s of length %d, want %d", str, len(str), want)
+	}
+	if _, n, err := codec.Decode(ctx, keyID, str); err != nil || n != 17 {
+		t.Errorf("decoding %s: got (%d, %v), want 17", str, n, err)
+	}
+
+	// Decoding the short format requires a KeyEncoder.
+	bare, err := encid.NewCodec(encid.WithKeyStore(struct{ encid.KeyStore }{ks}), short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := bare.Decode(ctx, keyID, str); err == nil {
+		t.Error("got nil error decoding short format without a KeyEncoder")
+	}
+}
+
+func TestNumeric(t *testing.T) {
+	var (
+		ctx = context.Background()
+		ks  = testutil.KeyStore{NumTypes: 100, Ver: 2}
+	)
+
+	for _, digits := range []int{encid.MinDigits, 7, 9, 12, encid.MaxDigits} {
+		limit := int64(math.Pow10(digits))
+		for _, n := range []int64{0, 1, 17, limit / 2, limit - 1} {
+			keyID, m, err := encid.EncodeNumber(ctx, ks, 1, n, digits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m < 0 || m >= limit {
+				t.Errorf("encoding %d with %d digits: got %d, out of range", n, digits, m)
+			}
+			if typ, got, err := encid.DecodeNumber(ctx, ks, keyID, m, digits); err != nil || typ != 1 || got != n {
+				t.Errorf("decoding %d with %d digits: got (%d, %d, %v), want (1, %d, nil)", m, digits, typ, got, err, n)
+			}
+
+			keyID, str, err := encid.EncodeDigits(ctx, ks, 1, n, digits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(str) != digits {
+				t.Errorf("encoding %d with %d digits: got %s", n, digits, str)
+			}
+			if typ, got, err := encid.DecodeDigits(ctx, ks, keyID, str); err != nil || typ != 1 || got != n {
+				t.Errorf("decoding %s: got (%d, %d, %v), want (1, %d, nil)", str, typ, got, err, n)
+			}
+		}
+	}
+
+	// Every 6-digit number has a distinct encoding.
+	seen := make(map[int64]bool)
+	for n := range int64(1000000) {
+		_, m, err := encid.EncodeNumber(ctx, ks, 1, n, 6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[m] {
+			t.Fatalf("encoding %d: got duplicate %d", n, m)
+		}
+		seen[m] = true
+	}
+
+	if _, _, err := encid.EncodeNumber(ctx, ks, 1, 1000000, 6); err == nil {
+		t.Error("got nil error encoding out-of-range number")
+	}
+	if _, _, err := encid.EncodeNumber(ctx, ks, 1, 17, encid.MinDigits-1); err == nil {
+		t.Error("got nil error encoding with too few digits")
+	}
+	if _, _, err := encid.EncodeNumber(ctx, ks, 1, 17, encid.MaxDigits+1); err == nil {
+		t.Error("got nil error encoding with too many digits")
+	}
+	for _, inp := range []string{"12345a", "-12345", "12345", "1234567890123456789"} {
+		if _, _, err := encid.DecodeDigits(ctx, ks, 1, inp); !errors.Is(err, encid.ErrInvalidToken) {
+			t.Errorf("decoding %q: got error %v, want %v", inp, err, encid.ErrInvalidToken)
+		}
+	}
+}
+
+func TestCheckChar(t *testing.T) {
+	var (
+		ctx = context.Background()
+		ks  = &countingKeyStore{KeyStore: testutil.KeyStore{NumTypes: 100, Ver: 2}}
+	)
+
+	for _, base := range []basexx.Base{basexx.Base30, encid.Crockford32, encid.Base58, encid.Hex} {
+		t.Run(fmt.Sprintf("base%d", base.N()), func(t *testing.T) {
+			opts := []encid.Option{encid.WithBase(base), encid.WithCheckChar()}
+
+			keyID, str, err := encid.Encode(ctx, ks, 1, 17, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typ, n, err := encid.Decode(ctx, ks, keyID, str, opts...); err != nil || typ != 1 || n != 17 {
+				t.Fatalf("decoding %s: got (%d, %d, %v), want (1, 17, nil)", str, typ, n, err)
+			}
+
+			// Every single-character substitution is caught without consulting the keystore.
+			before := ks.decoders
+			for i := range len(str) {
+				val, err := base.Decode(str[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				for delta := int64(1); delta < base.N(); delta++ {
+					ch, err := base.Encode((val + delta) % base.N())
+					if err != nil {
+						t.Fatal(err)
+					}
+					typo := str[:i] + string(ch) + str[i+1:]
+					if _, _, err := encid.Decode(ctx, ks, keyID, typo, opts...); !errors.Is(err, encid.ErrChecksum) {
+						t.Fatalf("decoding %s (typo of %s): got error %v, want %v", typo, str, err, encid.ErrChecksum)
+					}
+				}
+			}
+			// So is a substitution by a character that is not a digit of the base.
+			typo := "!" + str[1:]
+			if _, _, err := encid.Decode(ctx, ks, keyID, typo, opts...); !errors.Is(err, encid.ErrChecksum) {
+				t.Errorf("decoding %s (typo of %s): got error %v, want %v", typo, str, err, encid.ErrChecksum)
+			}
+			if ks.decoders != before {
+				t.Errorf("got %d keystore lookups for typos, want 0", ks.decoders-before)
+			}
+
+			tok, err := encid.EncodeToken(ctx, ks, 1, 18, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, n, err := encid.DecodeToken(ctx, ks, tok, opts...); err != nil || n != 18 {
+				t.Errorf("decoding token %s: got (%d, %v), want 18", tok, n, err)
+			}
+			if _, _, err := encid.DecodeToken(ctx, ks, tok[:len(tok)-1], opts...); !errors.Is(err, encid.ErrChecksum) {
+				t.Errorf("decoding truncated token: got error %v, want %v", err, encid.ErrChecksum)
+			}
+		})
+	}
+
+	// Batch decoding reports checksum errors individually.
+	opts := []encid.Option{encid.WithCheckChar(), encid.WithPadding()}
+	keyID, str, err := encid.Encode(ctx, ks, 1, 17, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := str[:len(str)-1] + string(str[len(str)-2])
+	if bad == str {
+		bad = str[:len(str)-1] + string(str[len(str)-3])
+	}
+	results := encid.DecodeBatch(ctx, ks, []encid.Encoded{{KeyID: keyID, Str: bad}, {KeyID: keyID, Str: str}}, opts...)
+	if !errors.Is(results[0].Err, encid.ErrChecksum) {
+		t.Errorf("batch result 0: got error %v, want %v", results[0].Err, encid.ErrChecksum)
+	}
+	if r := results[1]; r.Err != nil || r.N != 17 {
+		t.Errorf("batch result 1: got (%d, %v), want 17", r.N, r.Err)
+	}
+}
+
+func TestConfusables(t *testing.T) {
+	var (
+		ctx = context.Background()
+		ks  = testutil.KeyStore{NumTypes: 100, Ver: 2}
+	)
+
+	for _, base := range []basexx.Base{basexx.Base30, encid.Hex} {
+		// Find a string containing the digits 0 and 1.
+		for n := int64(1); ; n++ {
+			keyID, str, err := encid.Encode(ctx, ks, 1, n, encid.WithBase(base), encid.WithCheckChar())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(str, "0") || !strings.Contains(str, "1") {
+				continue
+			}
+			confused := strings.NewReplacer("0", "O", "1", "l").Replace(str)
+			if _, got, err := encid.Decode(ctx, ks, keyID, confused, encid.WithBase(base), encid.WithCheckChar()); err != nil || got != n {
+				t.Errorf("decoding %s (for %s): got (%d, %v), want %d", confused, str, got, err, n)
+			}
+			break
+		}
+	}
+}
+
+func TestGroups(t *testing.T) {
+	var (
+		ctx = context.Background()
+		ks  = testutil.KeyStore{NumTypes: 100, Ver: 2}
+	)
+
+	keyID, plain, err := encid.Encode(ctx, ks, 1, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, grouped, err := encid.Encode(ctx, ks, 1, 17, encid.WithGroups(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want []string
+	for s := plain; len(s) > 0; {
+		k := min(4, len(s))
+		want = append(want, s[:k])
+		s = s[k:]
+	}
+	if got := strings.Split(grouped, "-"); !slices.Equal(got, want) {
+		t.Errorf("got %s, want %s", grouped, strings.Join(want, "-"))
+	}
+
+	for _, inp := range []string{grouped, strings.ReplaceAll(grouped, "-", " "), " " + strings.ToUpper(grouped) + "\n"} {
+		if _, n, err := encid.Decode(ctx, ks, keyID, inp); err != nil || n != 17 {
+			t.Errorf("decoding %q: got (%d, %v), want 17", inp, n, err)
+		}
+	}
+
+	// Groups come after the prefix and include the check character.
+	opts := []encid.Option{encid.WithPrefix(1, "usr_"), encid.WithCheckChar(), encid.WithGroups(5)}
+	tok, err := encid.EncodeToken(ctx, ks, 1, 18, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(tok, "usr_") || strings.Contains(tok[:9], "-") || tok[9] != '-' {
+		t.Errorf("got %s, want usr_ followed by groups of 5", tok)
+	}
+	if _, n, err := encid.DecodeToken(ctx, ks, tok, opts...); err != nil || n != 18 {
+		t.Errorf("decoding %s: got (%d, %v), want 18", tok, n, err)
+	}
+
+	// In Base64URL, hyphens are digits.
+	for n := int64(1); ; n++ {
+		keyID, str, err := encid.Encode(ctx, ks, 1, n, encid.WithBase(encid.Base64URL))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(str, "-") {
+			continue
+		}
+		if _, got, err := encid.Decode(ctx, ks, keyID, str, encid.WithBase(encid.Base64URL)); err != nil || got != n {
+			t.Errorf("decoding %s: got (%d, %v), want %d", str, got, err, n)
+		}
+		break
+	}
+
+	// So grouping with Base64URL is an error.
+	b64opts := []encid.Option{encid.WithBase(encid.Base64URL), encid.WithGroups(4)}
+	if _, str, err := encid.Encode(ctx, ks, 1, 17, b64opts...); err == nil {
+		t.Errorf("got %s, want error grouping Base64URL", str)
+	}
+	if tok, err := encid.EncodeToken(ctx, ks, 1, 17, b64opts...); err == nil {
+		t.Errorf("got %s, want error grouping Base64URL", tok)
+	}
+	if _, err := encid.NewCodec(append(b64opts, encid.WithKeyStore(ks))...); err == nil {
+		t.Error("got no error creating codec grouping Base64URL")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		ks      = testutil.KeyStore{NumTypes: 100, Ver: 2, KeyVers: map[int64]int{3: 1}}
+		now     = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+		expires = now.Add(time.Hour)
+	)
+
+	clock := func(t time.Time) encid.Option {
+		return encid.WithClock(func() time.Time { return t })
+	}
+
+	keyID, str, err := encid.Encode(ctx, ks, 1, 17, encid.WithExpiry(expires))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, n, err := encid.Decode(ctx, ks, keyID, str, clock(now)); err != nil || n != 17 {
+		t.Errorf("decoding before expiry: got (%d, %v), want 17", n, err)
+	}
+	if _, _, err := encid.Decode(ctx, ks, keyID, str, clock(expires)); !errors.Is(err, encid.ErrExpired) {
+		t.Errorf("decoding at expiry: got error %v, want %v", err, encid.ErrExpired)
+	}
+	if _, _, err := encid.Decode(ctx, ks, keyID, str, encid.WithExpiryCheck()); !errors.Is(err, encid.ErrExpired) {
+		t.Errorf("decoding with default clock: got error %v, want %v", err, encid.ErrExpired)
+	}
+
+	// Without opting in to expiry times,
+	// a string that has one is rejected like any other garbage.
+	if _, _, err := encid.Decode(ctx, ks, keyID, str); !errors.Is(err, encid.ErrInvalidToken) {
+		t.Errorf("decoding without expiry check: got error %v, want %v", err, encid.ErrInvalidToken)
+	}
+
+	codec, err := encid.NewCodec(encid.WithKeyStore(ks), encid.WithExpiry(expires), clock(now.Add(2*time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := codec.EncodeToken(ctx, 1, 18)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := codec.DecodeToken(ctx, tok); !errors.Is(err, encid.ErrExpired) {
+		t.Errorf("decoding expired token: got error %v, want %v", err, encid.ErrExpired)
+	}
+	if _, n, err := encid.DecodeToken(ctx, ks, tok, clock(now)); err != nil || n != 18 {
+		t.Errorf("decoding token before expiry: got (%d, %v), want 18", n, err)
+	}
+	if _, _, err := encid.DecodeToken(ctx, ks, tok); !errors.Is(err, encid.ErrInvalidToken) {
+		t.Errorf("decoding token without expiry check: got error %v, want %v", err, encid.ErrInvalidToken)
+	}
+
+	results := encid.DecodeBatch(ctx, ks, []encid.Encoded{{KeyID: keyID, Str: str}}, clock(now))
+	if r := results[0]; r.Err != nil || r.N != 17 {
+		t.Errorf("decoding batch before expiry: got (%d, %v), want 17", r.N, r.Err)
+	}
+
+	// Strings without an expiry time are unaffected by the clock.
+	keyID, str, err = encid.Encode(ctx, ks, 1, 19)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, n, err := encid.Decode(ctx, ks, keyID, str, clock(expires)); err != nil || n != 19 {
+		t.Errorf("decoding without expiry: got (%d, %v), want 19", n, err)
+	}
+
+	if _, _, err := encid.Encode(ctx, ks, 1, 20, encid.WithExpiry(time.Date(2200, 1, 1, 0, 0, 0, 0, time.UTC))); err == nil {
+		t.Error("got nil error encoding out-of-range expiry time")
+	}
+
+	// Version-1 keys and the short format cannot hold an expiry time.
+	if _, _, err := encid.Encode(ctx, ks, 3, 20, encid.WithExpiry(expires)); err == nil {
+		t.Error("got nil error encoding expiry time with version-1 key")
+	}
+	if _, _, err := encid.Encode(ctx, ks, 1, 20, encid.WithExpiry(expires), encid.WithShortFormat()); err == nil {
+		t.Error("got nil error encoding expiry time in the short format")
+	}
+}
+
+func TestScope(t *testing.T) {
+	var (
+		ctx = context.Background()
+		ks  = testutil.KeyStore{NumTypes: 100, Ver: 2, KeyVers: map[int64]int{3: 1}}
+		a   = encid.WithScope([]byte("tenant-a"))
+		b   = encid.WithScope([]byte("tenant-b"))
+	)
+
+	keyID, str, err := encid.Encode(ctx, ks, 1, 17, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, n, err := encid.Decode(ctx, ks, keyID, str, a); err != nil || n != 17 {
+		t.Errorf("decoding with same scope: got (%d, %v), want 17", n, err)
+	}
+	if _, _, err := encid.Decode(ctx, ks, keyID, str, b); !errors.Is(err, encid.ErrInvalidToken) {
+		t.Errorf("decoding with other scope: got error %v, want %v", err, encid.ErrInvalidToken)
+	}
+	if _, _, err := encid.Decode(ctx, ks, keyID, str); !errors.Is(err, encid.ErrInvalidToken) {
+		t.Errorf("decoding without scope: got error %v, want %v", err, encid.ErrInvalidToken)
+	}
+
+	// An unscoped string doesn't decode with a scope.
+	_, plain, err := encid.Encode(ctx, ks, 1, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain == str {
+		t.Errorf("got same string %s with and without scope", str)
+	}
+	if _, _, err := encid.Decode(ctx, ks, keyID, plain, a); !errors.Is(err, encid.ErrInvalidToken) {
+		t.Errorf("decoding unscoped string with scope: got error %v, want %v", err, encid.ErrInvalidToken)
+	}
+
+	// An empty scope is the same as none.
+	if _, n, err := encid.Decode(ctx, ks, keyID, plain, encid.WithScope(nil)); err != nil || n != 17 {
+		t.Errorf("decoding with empty scope: got (%d, %v), want 17", n, err)
+	}
+
+	// Tokens, with an expiry time too.
+	var (
+		now   = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+		clock = encid.WithClock(func() time.Time { return now })
+	)
+	tok, err := encid.EncodeToken(ctx, ks, 1, 18, a, encid.WithExpiry(now.Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, n, err := encid.DecodeToken(ctx, ks, tok, a, clock); err != nil || n != 18 {
+		t.Errorf("decoding token with same scope: got (%d, %v), want 18", n, err)
+	}
+	if _, _, err := encid.DecodeToken(ctx, ks, tok, b, clock); !errors.Is(err, encid.ErrInvalidToken) {
+		t.Errorf("decoding token with other scope: got error %v, want %v", err, encid.ErrInvalidToken)
+	}
+
+	// Find two scopes whose hashes agree in the bytes
+	// that line up with the zero padding of a block with an expiry time.
+	// Mixing the scope into the padding alone
+	// would not tell these apart.
+	var (
+		seen   = make(map[[3]byte]string)
+		sa, sb string
+	)
+	for i := 0; sa == ""; i++ {
+		s := fmt.Sprintf("tenant-%d", i)
+		h := sha256.Sum256([]byte(s))
+		k := [3]byte(h[4:7])
+		if other, ok := seen[k]; ok {
+			sa, sb = other, s
+		}
+		seen[k] = s
+	}
+	for n := int64(0); n < 100; n++ {
+		tok, err := encid.EncodeToken(ctx, ks, 1, n, encid.WithScope([]byte(sa)), encid.WithExpiry(now.Add(time.Hour)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := encid.DecodeToken(ctx, ks, tok, encid.WithScope([]byte(sb)), clock); !errors.Is(err, encid.ErrInvalidToken) {
+			t.Errorf("decoding token %d with scope %s instead of %s: got error %v, want %v", n, sb, sa, err, encid.ErrInvalidToken)
+		}
+	}
+
+	// Version-1 keys and the short format cannot have scopes.
+	for _, c := range []struct {
+		typ   int
+		short bool
+	}{{typ: 3}, {typ: 1, short: true}} {
+		var opts []encid.Option
+		if c.short {
+			opts = append(opts, encid.WithShortFormat())
+		}
+		if _, _, err := encid.Encode(ctx, ks, c.typ, 19, append(opts, a)...); err == nil {
+			t.Errorf("got nil error encoding scope with type %d (short: %v)", c.typ, c.short)
+		}
+		keyID, str, err := encid.Encode(ctx, ks, c.typ, 19, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := encid.Decode(ctx, ks, keyID, str, append(opts, a)...); !errors.Is(err, encid.ErrInvalidToken) {
+			t.Errorf("decoding with scope and type %d (short: %v): got error %v, want %v", c.typ, c.short, err, encid.ErrInvalidToken)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package encid
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/bobg/errors"
+)
+
+// ErrExpired is the type of error produced when decoding an input
+// whose expiry time has passed.
+// See [WithExpiry].
+var ErrExpired = errors.New("expired")
+
+// WithExpiry is an option that causes encoded strings and tokens
+// to include an expiry time,
+// encrypted along with the number.
+// Such an input decodes only with [WithExpiryCheck]
+// (or with WithExpiry itself, or [WithClock]);
+// decoding it after the expiry time
+// produces an error wrapping [ErrExpired].
+//
+// The expiry time is kept to the second,
+// and must be no later than 2106.
+// It requires a key with encoding version 2
+// (see [Versioner] and [KeyVersioner]).
+// Strings that include an expiry time
+// are no longer than others,
+// but can be authenticated less strongly when decoding
+// (see [ErrInvalidToken]):
+// three bytes of the block are checked rather than seven.
+// This is why decoding must opt in to them.
+func WithExpiry(t time.Time) Option {
+	return func(c *config) {
+		c.expires = t
+		c.expiring = true
+	}
+}
+
+// WithExpiryCheck is an option that causes decoding to accept inputs
+// that include an expiry time (see [WithExpiry]),
+// producing an error wrapping [ErrExpired] if it has passed.
+// Without it,
+// such inputs produce an error wrapping [ErrInvalidToken].
+func WithExpiryCheck() Option {
+	return func(c *config) {
+		c.expiring = true
+	}
+}
+
+// WithClock is an option that sets the clock used for checking expiry times when decoding.
+// The default is [time.Now].
+// It implies [WithExpiryCheck].
+func WithClock(now func() time.Time) Option {
+	return func(c *config) {
+		c.now = now
+		c.expiring = true
+	}
+}
+
+// blockOpts are the options that affect the contents of encrypted blocks.
+type blockOpts struct {
+	// expires, if not zero, is the expiry time to include in encoded blocks.
+	expires time.Time
+
+	// expiring means decoded blocks may include an expiry time.
+	// See WithExpiryCheck.
+	expiring bool
+
+	// now is the clock for checking expiry times in decoded blocks.
+	// If it is nil, time.Now is used.
+	now func() time.Time
+
+	// scope, if not nil, is the hash of the scope to mix into blocks.
+	// See WithScope.
+	scope []byte
+
+	// short means blocks are in the short format.
+	// See WithShortFormat.
+	short bool
+}
+
+// needsV2 tells whether bo requires a version-2 key.
+func (bo blockOpts) needsV2() bool {
+	return !bo.expires.IsZero() || len(bo.scope) > 0
+}
+
+const (
+	// expiringBlock is the version byte of a version-2 block with an expiry time.
+	// The expiry time follows the number,
+	// as the Unix time in seconds
+	// in expirySize little-endian bytes.
+	expiringBlock = 4
+
+	expirySize = 4
+)
+
+// putExpiry adds the expiry time in bo, if any, to buf,
+// which contains a version-2 block.
+func (bo blockOpts) putExpiry(buf *[aes.BlockSize]byte) error {
+	if bo.expires.IsZero() {
+		return nil
+	}
+	secs := bo.expires.Unix()
+	if secs < 0 || secs >= 1<<(8*expirySize) {
+		return fmt.Errorf("expiry time %s out of range", bo.expires)
+	}
+
+	var tbuf [8]byte
+	binary.LittleEndian.PutUint64(tbuf[:], uint64(secs))
+
+	buf[0] = expiringBlock
+	copy(buf[9:9+expirySize], tbuf[:expirySize])
+	return nil
+}
+
+// checkExpiry checks the zero padding and the expiry time in buf,
+// which contains a decrypted block with an expiry time.
+func (bo blockOpts) checkExpiry(buf *[aes.BlockSize]byte) error {
+	if !bo.expiring {
+		return errors.Wrap(ErrInvalidToken, "unexpected expiry time (see WithExpiryCheck)")
+	}
+
+	var zeroes [aes.BlockSize - 9 - expirySize]byte
+	if !bytes.Equal(buf[9+expirySize:], zeroes[:]) {
+		return errors.Wrap(ErrInvalidToken, "zero-padding check failed")
+	}
+
+	var tbuf [8]byte
+	copy(tbuf[:], buf[9:9+expirySize])
+	expires := time.Unix(int64(binary.LittleEndian.Uint64(tbuf[:])), 0)
+
+	now := time.Now
+	if bo.now != nil {
+		now = bo.now
+	}
+	if !now().Before(expires) {
+		return errors.Wrapf(ErrExpired, "at %s", expires.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -4,4 +4,8 @@ package encid
 var (
 	PrivateEncode = encode
 	PrivateDecode = decode
+
+	PrivateEncodeToken = encodeToken
 )
+
+type PrivateBlockOpts = blockOpts
@@ -6,8 +6,10 @@ require (
 	github.com/bobg/basexx/v2 v2.1.0
 	github.com/bobg/errors v1.1.0
 	github.com/bobg/subcmd/v2 v2.3.0
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.30
 	github.com/pressly/goose/v3 v3.24.3
+	golang.org/x/crypto v0.38.0
 )
 
 require (
@@ -0,0 +1,59 @@
+package encid
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WithGroups is an option that makes encoded strings and tokens easier to read aloud
+// by splitting them into groups of the given size,
+// separated by hyphens,
+// like this: 4gsb-6bwn-svzd-r9sg.
+// The last group may be shorter than the others.
+// A size of zero or less means no grouping.
+//
+// The grouping comes after any padding or check character
+// (see [WithPadding] and [WithCheckChar])
+// and does not include any prefix (see [WithPrefix]).
+//
+// Decoding ignores whitespace,
+// and hyphens too,
+// with or without this option.
+//
+// Grouping is an error with a base that has the hyphen as a digit,
+// such as [Base64URL],
+// since the separators could not be told apart from the digits.
+func WithGroups(size int) Option {
+	return func(c *config) {
+		c.groupSize = size
+	}
+}
+
+// checkGroups reports an error if the grouping option is set
+// and the base has the hyphen as a digit.
+func (c *config) checkGroups() error {
+	if c.groupSize <= 0 {
+		return nil
+	}
+	if _, err := c.base.Decode('-'); err == nil {
+		return fmt.Errorf("cannot split into groups with a base that uses hyphens as digits")
+	}
+	return nil
+}
+
+// group splits s into hyphen-separated groups,
+// if the grouping option is set.
+func (c *config) group(s string) string {
+	if c.groupSize <= 0 || len(s) <= c.groupSize {
+		return s
+	}
+
+	var b strings.Builder
+	for len(s) > c.groupSize {
+		b.WriteString(s[:c.groupSize])
+		b.WriteByte('-')
+		s = s[c.groupSize:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
@@ -0,0 +1,126 @@
+package encid
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding"
+	"encoding/json"
+	"fmt"
+)
+
+// Kind describes a kind of [ID]:
+// the keystore to use for encoding and decoding it,
+// and the key type.
+//
+// An ID's Kind is its type parameter,
+// and its methods are called on the zero value of that type.
+// Implementations are therefore typically empty structs, like this:
+//
+//	type UserKind struct{}
+//
+//	func (UserKind) KeyStore() encid.KeyStore { return myKeyStore }
+//	func (UserKind) Type() int               { return 1 }
+//
+//	type UserID = encid.ID[UserKind]
+type Kind interface {
+	KeyStore() KeyStore
+	Type() int
+}
+
+// ID is an integer ID that is represented externally as an encrypted token.
+// See [EncodeToken].
+//
+// Internally,
+// and in a database (via its [driver.Valuer] and [sql.Scanner] methods),
+// an ID is a plain int64.
+// When marshaled as text or JSON, or formatted as a string,
+// it is a token encoded with a key of type K.Type() from K.KeyStore().
+// When unmarshaled, the token must decode with a key of that same type.
+type ID[K Kind] int64
+
+var (
+	_ fmt.Stringer             = ID[Kind](0)
+	_ encoding.TextMarshaler   = ID[Kind](0)
+	_ encoding.TextUnmarshaler = (*ID[Kind])(nil)
+	_ json.Marshaler           = ID[Kind](0)
+	_ json.Unmarshaler         = (*ID[Kind])(nil)
+	_ driver.Valuer            = ID[Kind](0)
+	_ sql.Scanner              = (*ID[Kind])(nil)
+)
+
+// String implements [fmt.Stringer].
+// It produces the token for id.
+// If encoding fails,
+// the result is an error message in the style of the fmt package.
+func (id ID[K]) String() string {
+	text, err := id.MarshalText()
+	if err != nil {
+		return fmt.Sprintf("%%!(ENCID_ERROR=%s)", err)
+	}
+	return string(text)
+}
+
+// MarshalText implements [encoding.TextMarshaler].
+func (id ID[K]) MarshalText() ([]byte, error) {
+	var k K
+	tok, err := EncodeToken(context.Background(), k.KeyStore(), k.Type(), int64(id))
+	return []byte(tok), err
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler].
+func (id *ID[K]) UnmarshalText(text []byte) error {
+	var k K
+	typ, n, err := DecodeToken(context.Background(), k.KeyStore(), string(text))
+	if err != nil {
+		return err
+	}
+	if typ != k.Type() {
+		return &WrongTypeError{Got: typ, Want: []int{k.Type()}}
+	}
+	*id = ID[K](n)
+	return nil
+}
+
+// MarshalJSON implements [json.Marshaler].
+// The result is a JSON string containing the token for id.
+func (id ID[K]) MarshalJSON() ([]byte, error) {
+	text, err := id.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(string(text))
+}
+
+// UnmarshalJSON implements [json.Unmarshaler].
+// The input must be a JSON string containing a token.
+func (id *ID[K]) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return id.UnmarshalText([]byte(s))
+}
+
+// Value implements [driver.Valuer].
+// The result is the plain int64 value of id.
+func (id ID[K]) Value() (driver.Value, error) {
+	return int64(id), nil
+}
+
+// Scan implements [sql.Scanner].
+// It accepts a plain integer,
+// or a token as a string or byte slice.
+func (id *ID[K]) Scan(src any) error {
+	switch src := src.(type) {
+	case int64:
+		*id = ID[K](src)
+		return nil
+	case string:
+		return id.UnmarshalText([]byte(src))
+	case []byte:
+		return id.UnmarshalText(src)
+	default:
+		return fmt.Errorf("cannot scan %T into ID", src)
+	}
+}
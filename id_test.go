@@ -0,0 +1,119 @@
+package encid_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/bobg/encid"
+	"github.com/bobg/encid/testutil"
+)
+
+type (
+	userKind struct{}
+	docKind  struct{}
+)
+
+func (userKind) KeyStore() encid.KeyStore { return testutil.KeyStore{NumTypes: 100, Ver: 2} }
+func (userKind) Type() int                { return 1 }
+
+func (docKind) KeyStore() encid.KeyStore { return testutil.KeyStore{NumTypes: 100, Ver: 2} }
+func (docKind) Type() int                { return 2 }
+
+func TestIDJSON(t *testing.T) {
+	type record struct {
+		User encid.ID[userKind] `json:"user"`
+		Doc  encid.ID[docKind]  `json:"doc"`
+	}
+
+	inp := record{User: 17, Doc: 42}
+
+	j, err := json.Marshal(inp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got record
+	if err := json.Unmarshal(j, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != inp {
+		t.Errorf("got %+v, want %+v", got, inp)
+	}
+
+	var generic map[string]string
+	if err := json.Unmarshal(j, &generic); err != nil {
+		t.Fatal(err)
+	}
+	if generic["user"] != inp.User.String() {
+		t.Errorf("got user token %s, want %s", generic["user"], inp.User.String())
+	}
+
+	var wrong encid.ID[docKind]
+	if err := wrong.UnmarshalText([]byte(generic["user"])); !errors.Is(err, encid.ErrWrongType) {
+		t.Errorf("decoding user ID as doc ID: got error %v, want %v", err, encid.ErrWrongType)
+	}
+}
+
+func TestDecodeAs(t *testing.T) {
+	ctx := context.Background()
+
+	tok := encid.ID[userKind](17).String()
+
+	got, err := encid.DecodeTokenAs[userKind](ctx, tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 17 {
+		t.Errorf("got %d, want 17", got)
+	}
+
+	if got, err := encid.DecodeTokenAs[docKind](ctx, tok); !errors.Is(err, encid.ErrWrongType) || got != 0 {
+		t.Errorf("decoding user token as doc: got (%d, %v), want (0, %v)", got, err, encid.ErrWrongType)
+	}
+
+	var k userKind
+	keyID, str, err := encid.Encode(ctx, k.KeyStore(), k.Type(), 18)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := encid.DecodeAs[userKind](ctx, keyID, str); err != nil || got != 18 {
+		t.Errorf("got (%d, %v), want (18, nil)", got, err)
+	}
+	if got, err := encid.DecodeAs[docKind](ctx, keyID, str); !errors.Is(err, encid.ErrWrongType) || got != 0 {
+		t.Errorf("decoding user string as doc: got (%d, %v), want (0, %v)", got, err, encid.ErrWrongType)
+	}
+}
+
+func TestIDSQL(t *testing.T) {
+	id := encid.ID[userKind](17)
+
+	v, err := id.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != int64(17) {
+		t.Errorf("got value %v, want 17", v)
+	}
+
+	var got encid.ID[userKind]
+	if err := got.Scan(int64(17)); err != nil {
+		t.Fatal(err)
+	}
+	if got != id {
+		t.Errorf("got %d, want %d", got, id)
+	}
+
+	got = 0
+	if err := got.Scan(id.String()); err != nil {
+		t.Fatal(err)
+	}
+	if got != id {
+		t.Errorf("got %d, want %d", got, id)
+	}
+
+	if err := got.Scan(3.14); err == nil {
+		t.Error("got nil error scanning float")
+	}
+}
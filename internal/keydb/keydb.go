@@ -0,0 +1,458 @@
+// Package keydb implements the database-backed keystores
+// in the sqlite and postgres packages of this module.
+// Those packages supply the database driver and the schema migrations;
+// the queries here are portable between them.
+package keydb
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/bobg/errors"
+
+	"github.com/bobg/encid"
+)
+
+// New creates a keystore using db,
+// whose schema must be up to date.
+// The newcipher function takes a key and returns a cipher for encrypting and decrypting.
+// If newcipher is nil, it defaults to [aes.NewCipher].
+//
+// If the keystore is new (i.e., contains no keys),
+// the version number of the keystore is set to 2.
+func New(ctx context.Context, db *sql.DB, newcipher func([]byte) (cipher.Block, error)) (*KeyStore, error) {
+	var nkeys int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM keys`).Scan(&nkeys); err != nil {
+		return nil, errors.Wrap(err, "counting keys")
+	}
+	if nkeys == 0 {
+		const q = `UPDATE version SET version = 2 WHERE singleton = 0 AND version < 2`
+		if _, err := db.ExecContext(ctx, q); err != nil {
+			return nil, errors.Wrap(err, "updating version")
+		}
+	}
+
+	var version int
+	err := db.QueryRowContext(ctx, `SELECT version FROM version WHERE singleton = 0`).Scan(&version)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting version")
+	}
+
+	if newcipher == nil {
+		newcipher = aes.NewCipher
+	}
+
+	return &KeyStore{
+		store: &store{
+			db:        db,
+			newcipher: newcipher,
+			version:   version,
+		},
+	}, nil
+}
+
+// KeyStore is an implementation of encid.KeyStore backed by a SQL database.
+type KeyStore struct {
+	*store
+
+	// fixedTenant, if not nil, is the tenant for all operations,
+	// overriding any tenant in the context.
+	// See ForTenant.
+	fixedTenant *string
+}
+
+// store is the state shared by a KeyStore and its per-tenant views.
+type store struct {
+	db        *sql.DB
+	newcipher func([]byte) (cipher.Block, error)
+	version   int
+
+	// kek is the key-encryption key, or nil if there is none.
+	// See SetMasterKey.
+	kek cipher.AEAD
+}
+
+var (
+	_ encid.KeyStore          = &KeyStore{}
+	_ encid.Versioner         = &KeyStore{}
+	_ encid.KeyVersioner      = &KeyStore{}
+	_ encid.DecoderBatcher    = &KeyStore{}
+	_ encid.KeyEncoder        = &KeyStore{}
+	_ encid.VersionedKeyStore = &KeyStore{}
+)
+
+// DB returns the keystore's database handle.
+func (ks *KeyStore) DB() *sql.DB {
+	return ks.db
+}
+
+// DecoderByID implements [encid.KeyStore].
+func (ks *KeyStore) DecoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), error) {
+	typ, dec, _, err := ks.VersionedDecoderByID(ctx, id)
+	return typ, dec, err
+}
+
+// VersionedDecoderByID implements [encid.VersionedKeyStore].
+func (ks *KeyStore) VersionedDecoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), int, error) {
+	typ, ciph, version, err := ks.cipherByID(ctx, id)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	return typ, ciph.Decrypt, version, nil
+}
+
+// EncoderByID implements [encid.KeyEncoder].
+func (ks *KeyStore) EncoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), error) {
+	typ, ciph, _, err := ks.cipherByID(ctx, id)
+	if err != nil {
+		return 0, nil, err
+	}
+	return typ, ciph.Encrypt, nil
+}
+
+// cipherByID looks up the key with the given ID
+// and returns its type, a cipher using it, and its version.
+func (ks *KeyStore) cipherByID(ctx context.Context, id int64) (typ int, ciph cipher.Block, version int, err error) {
+	const q = `SELECT typ, k, wrapped, state, version FROM keys WHERE id = $1 AND tenant = $2`
+
+	var (
+		k       []byte
+		wrapped bool
+		state   encid.KeyState
+	)
+
+	err = ks.db.QueryRowContext(ctx, q, id, ks.tenant(ctx)).Scan(&typ, &k, &wrapped, &state, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil, 0, encid.ErrNotFound
+	}
+	if err != nil {
+		return 0, nil, 0, errors.Wrapf(err, "retrieving key %d", id)
+	}
+	if state == encid.KeyRevoked {
+		return 0, nil, 0, encid.ErrRevoked
+	}
+
+	if k, err = ks.unwrap(id, k, wrapped); err != nil {
+		return 0, nil, 0, err
+	}
+
+	ciph, err = ks.newcipher(k)
+	if err != nil {
+		return 0, nil, 0, errors.Wrapf(err, "creating cipher for key %d", id)
+	}
+
+	return typ, ciph, version, nil
+}
+
+// DecodersByID implements [encid.DecoderBatcher].
+// It retrieves the requested keys in batches of up to maxBatch.
+func (ks *KeyStore) DecodersByID(ctx context.Context, ids []int64) (map[int64]encid.Decoder, error) {
+	result := make(map[int64]encid.Decoder)
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), maxBatch)]
+		ids = ids[len(batch):]
+		if err := ks.decodersByID(ctx, batch, result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// maxBatch is the most keys that DecodersByID retrieves in a single query,
+// keeping well under the databases' limits on query parameters.
+const maxBatch = 500
+
+// decodersByID retrieves the keys with the given IDs in a single query,
+// adding their decoders to result.
+func (ks *KeyStore) decodersByID(ctx context.Context, ids []int64, result map[int64]encid.Decoder) error {
+	var (
+		placeholders = make([]string, len(ids))
+		args         = []any{ks.tenant(ctx)}
+	)
+	for i, id := range ids {
+		placeholders[i] = fmt.Sprintf("$%d", i+2)
+		args = append(args, id)
+	}
+
+	q := `SELECT id, typ, k, wrapped, state, version FROM keys WHERE tenant = $1 AND id IN (` + strings.Join(placeholders, ", ") + `)`
+
+	rows, err := ks.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return errors.Wrap(err, "retrieving keys")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id      int64
+			k       []byte
+			wrapped bool
+			state   encid.KeyState
+			d       encid.Decoder
+		)
+		if err := rows.Scan(&id, &d.Type, &k, &wrapped, &state, &d.Version); err != nil {
+			return errors.Wrap(err, "scanning key")
+		}
+		result[id] = ks.decoder(id, k, wrapped, state, d)
+	}
+	return errors.Wrap(rows.Err(), "iterating over keys")
+}
+
+// decoder fills in the Decrypt and Encrypt fields, or the Err field, of d
+// for the key with the given ID.
+func (ks *KeyStore) decoder(id int64, k []byte, wrapped bool, state encid.KeyState, d encid.Decoder) encid.Decoder {
+	if state == encid.KeyRevoked {
+		return encid.Decoder{Err: encid.ErrRevoked}
+	}
+
+	k, err := ks.unwrap(id, k, wrapped)
+	if err != nil {
+		return encid.Decoder{Err: err}
+	}
+
+	ciph, err := ks.newcipher(k)
+	if err != nil {
+		return encid.Decoder{Err: errors.Wrapf(err, "creating cipher for key %d", id)}
+	}
+
+	d.Decrypt, d.Encrypt = ciph.Decrypt, ciph.Encrypt
+	return d
+}
+
+// EncoderByType implements [encid.KeyStore].
+// It uses the latest active key of the given type.
+func (ks *KeyStore) EncoderByType(ctx context.Context, typ int) (int64, func(dst, src []byte), error) {
+	id, enc, _, err := ks.VersionedEncoderByType(ctx, typ)
+	return id, enc, err
+}
+
+// VersionedEncoderByType implements [encid.VersionedKeyStore].
+// It uses the latest active key of the given type.
+func (ks *KeyStore) VersionedEncoderByType(ctx context.Context, typ int) (id int64, enc func(dst, src []byte), version int, err error) {
+	const q = `SELECT id, k, wrapped, version FROM keys WHERE typ = $1 AND tenant = $2 AND state = 0 ORDER BY id DESC LIMIT 1`
+
+	var (
+		k       []byte
+		wrapped bool
+	)
+
+	err = ks.db.QueryRowContext(ctx, q, typ, ks.tenant(ctx)).Scan(&id, &k, &wrapped, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil, 0, encid.ErrNotFound
+	}
+	if err != nil {
+		return 0, nil, 0, errors.Wrapf(err, "retrieving key for type %d", typ)
+	}
+
+	if k, err = ks.unwrap(id, k, wrapped); err != nil {
+		return 0, nil, 0, err
+	}
+
+	ciph, err := ks.newcipher(k)
+	if err != nil {
+		return 0, nil, 0, errors.Wrapf(err, "creating cipher for key %d", id)
+	}
+
+	return id, ciph.Encrypt, version, nil
+}
+
+// HasKeys tells whether the keystore has any keys of the given type,
+// in any state.
+func (ks *KeyStore) HasKeys(ctx context.Context, typ int) (bool, error) {
+	const q = `SELECT EXISTS (SELECT 1 FROM keys WHERE typ = $1 AND tenant = $2)`
+
+	var has bool
+	err := ks.db.QueryRowContext(ctx, q, typ, ks.tenant(ctx)).Scan(&has)
+	return has, errors.Wrapf(err, "checking for keys of type %d", typ)
+}
+
+// Version implements [encid.Versioner].
+func (ks *KeyStore) Version() int {
+	return ks.version
+}
+
+// KeyVersion implements [encid.KeyVersioner].
+func (ks *KeyStore) KeyVersion(ctx context.Context, id int64) (version int, err error) {
+	const q = `SELECT version FROM keys WHERE id = $1 AND tenant = $2`
+
+	err = ks.db.QueryRowContext(ctx, q, id, ks.tenant(ctx)).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, encid.ErrNotFound
+	}
+	return version, errors.Wrapf(err, "retrieving version of key %d", id)
+}
+
+// NewKey adds a new random key of the given type and size to the keystore,
+// returning its ID.
+// The key's version is the version of the keystore.
+func (ks *KeyStore) NewKey(ctx context.Context, typ, keysize int) (int64, error) {
+	return ks.newKeyTx(ctx, ks.tenant(ctx), typ, keysize, ks.version)
+}
+
+// NewKeyVersion is like [KeyStore.NewKey]
+// but gives the new key the specified version.
+func (ks *KeyStore) NewKeyVersion(ctx context.Context, typ, keysize, version int) (int64, error) {
+	if version < 1 {
+		return 0, fmt.Errorf("invalid key version %d", version)
+	}
+	return ks.newKeyTx(ctx, ks.tenant(ctx), typ, keysize, version)
+}
+
+// newKeyTx calls newKey in a transaction of its own.
+func (ks *KeyStore) newKeyTx(ctx context.Context, tenant string, typ, keysize, version int) (int64, error) {
+	tx, err := ks.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	id, err := ks.newKey(ctx, tx, tenant, typ, keysize, version)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, errors.Wrap(tx.Commit(), "committing transaction")
+}
+
+// newKey adds a new random key for the given tenant to the keystore,
+// wrapping it if there is a master key.
+// Since wrapping depends on the key's ID,
+// the row is inserted first and the key stored after.
+func (ks *KeyStore) newKey(ctx context.Context, tx *sql.Tx, tenant string, typ, keysize, version int) (id int64, err error) {
+	const q1 = `INSERT INTO keys (tenant, typ, k, version, wrapped) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+
+	if err := tx.QueryRowContext(ctx, q1, tenant, typ, []byte{}, version, ks.kek != nil).Scan(&id); err != nil {
+		return 0, errors.Wrap(err, "inserting key")
+	}
+
+	k := make([]byte, keysize)
+	if _, err := rand.Read(k); err != nil {
+		return 0, errors.Wrap(err, "generating key")
+	}
+
+	k, err = wrap(ks.kek, id, k)
+	if err != nil {
+		return 0, err
+	}
+
+	const q2 = `UPDATE keys SET k = $1 WHERE id = $2`
+
+	_, err = tx.ExecContext(ctx, q2, k, id)
+	return id, errors.Wrapf(err, "storing key %d", id)
+}
+
+// Migrate brings the keystore up to version 2,
+// adding a version 2 key for each tenant and type
+// whose latest active key is at version 1.
+func (ks *KeyStore) Migrate(ctx context.Context) error {
+	tx, err := ks.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	const q = `
+		SELECT tenant, typ, LENGTH(k) - CASE WHEN wrapped THEN CAST($1 AS INTEGER) ELSE 0 END FROM keys
+		WHERE version < 2 AND id IN (SELECT MAX(id) FROM keys WHERE state = 0 GROUP BY tenant, typ)
+	`
+
+	type oldKey struct {
+		tenant       string
+		typ, keysize int
+	}
+	var oldKeys []oldKey
+
+	rows, err := tx.QueryContext(ctx, q, WrapOverhead)
+	if err != nil {
+		return errors.Wrap(err, "querying latest keys")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k oldKey
+		if err := rows.Scan(&k.tenant, &k.typ, &k.keysize); err != nil {
+			return errors.Wrap(err, "scanning latest key")
+		}
+		oldKeys = append(oldKeys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "iterating over latest keys")
+	}
+
+	for _, k := range oldKeys {
+		if _, err := ks.newKey(ctx, tx, k.tenant, k.typ, k.keysize, 2); err != nil {
+			return errors.Wrapf(err, "adding version 2 key for type %d", k.typ)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE version SET version = 2 WHERE singleton = 0 AND version < 2`); err != nil {
+		return errors.Wrap(err, "updating version")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	ks.version = max(ks.version, 2)
+
+	return nil
+}
+
+// Rotate adds a new random key of the given type and size to the keystore,
+// returning its ID.
+// Other active keys of the same type become decode-only.
+func (ks *KeyStore) Rotate(ctx context.Context, typ, keysize int) (int64, error) {
+	tx, err := ks.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	tenant := ks.tenant(ctx)
+
+	const q = `UPDATE keys SET state = $1 WHERE typ = $2 AND tenant = $3 AND state = $4`
+	if _, err := tx.ExecContext(ctx, q, encid.KeyDecodeOnly, typ, tenant, encid.KeyActive); err != nil {
+		return 0, errors.Wrapf(err, "retiring keys of type %d", typ)
+	}
+
+	id, err := ks.newKey(ctx, tx, tenant, typ, keysize, ks.version)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, errors.Wrap(tx.Commit(), "committing transaction")
+}
+
+// Retire makes the key with the given ID decode-only.
+func (ks *KeyStore) Retire(ctx context.Context, id int64) error {
+	return ks.setState(ctx, id, encid.KeyDecodeOnly)
+}
+
+// Revoke revokes the key with the given ID.
+func (ks *KeyStore) Revoke(ctx context.Context, id int64) error {
+	return ks.setState(ctx, id, encid.KeyRevoked)
+}
+
+// setState advances the key with the given ID to the given state.
+// Keys never move back to an earlier state.
+func (ks *KeyStore) setState(ctx context.Context, id int64, state encid.KeyState) error {
+	const q = `UPDATE keys SET state = CASE WHEN state > $1 THEN state ELSE $1 END WHERE id = $2 AND tenant = $3`
+
+	res, err := ks.db.ExecContext(ctx, q, state, id, ks.tenant(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "updating state of key %d", id)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "counting updated keys")
+	}
+	if n == 0 {
+		return encid.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package keydb
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/bobg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrNoMasterKey is the error produced when using a wrapped key
+// in a keystore that has no master key.
+var ErrNoMasterKey = errors.New("no master key")
+
+// ErrWrongMasterKey is the error produced when a wrapped key
+// cannot be unwrapped with the master key.
+var ErrWrongMasterKey = errors.New("wrong master key")
+
+// WrapOverhead is the number of bytes that wrapping adds to a key:
+// a GCM nonce and a GCM tag.
+const WrapOverhead = 12 + 16
+
+// Parameters for deriving a master key from a passphrase.
+// See https://pkg.go.dev/golang.org/x/crypto/scrypt#Key.
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	saltLen      = 16
+)
+
+// SetMasterKey sets the key-encryption key for the keystore.
+// A nil master key clears it.
+// If the keystore has any wrapped keys,
+// one of them is unwrapped to check that the master key is the right one.
+func (ks *KeyStore) SetMasterKey(ctx context.Context, kek []byte) error {
+	aead, err := newKEK(kek)
+	if err != nil {
+		return err
+	}
+
+	if aead != nil {
+		var (
+			id int64
+			k  []byte
+		)
+		err := ks.db.QueryRowContext(ctx, `SELECT id, k FROM keys WHERE wrapped ORDER BY id LIMIT 1`).Scan(&id, &k)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// Nothing to check.
+		case err != nil:
+			return errors.Wrap(err, "retrieving wrapped key")
+		default:
+			if _, err := unwrap(aead, id, k); err != nil {
+				return err
+			}
+		}
+	}
+
+	ks.kek = aead
+	return nil
+}
+
+// PassphraseKey derives a master key from a passphrase,
+// using scrypt with a random salt that is stored in the keystore
+// and created on first use.
+func (ks *KeyStore) PassphraseKey(ctx context.Context, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errors.Wrap(err, "generating salt")
+	}
+	if _, err := ks.db.ExecContext(ctx, `INSERT INTO kdf (singleton, salt) VALUES (0, $1) ON CONFLICT DO NOTHING`, salt); err != nil {
+		return nil, errors.Wrap(err, "storing salt")
+	}
+	if err := ks.db.QueryRowContext(ctx, `SELECT salt FROM kdf WHERE singleton = 0`).Scan(&salt); err != nil {
+		return nil, errors.Wrap(err, "retrieving salt")
+	}
+
+	kek, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	return kek, errors.Wrap(err, "deriving master key")
+}
+
+// Rewrap re-encrypts all the keys in the keystore, of every tenant, under a new master key,
+// and makes that the keystore's master key.
+// A nil master key stores all keys unwrapped.
+func (ks *KeyStore) Rewrap(ctx context.Context, kek []byte) error {
+	aead, err := newKEK(kek)
+	if err != nil {
+		return err
+	}
+
+	tx, err := ks.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	type storedKey struct {
+		id      int64
+		k       []byte
+		wrapped bool
+	}
+	var keys []storedKey
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, k, wrapped FROM keys`)
+	if err != nil {
+		return errors.Wrap(err, "querying keys")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k storedKey
+		if err := rows.Scan(&k.id, &k.k, &k.wrapped); err != nil {
+			return errors.Wrap(err, "scanning key")
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "iterating over keys")
+	}
+
+	for _, k := range keys {
+		plain, err := ks.unwrap(k.id, k.k, k.wrapped)
+		if err != nil {
+			return err
+		}
+		rewrapped, err := wrap(aead, k.id, plain)
+		if err != nil {
+			return err
+		}
+
+		const q = `UPDATE keys SET k = $1, wrapped = $2 WHERE id = $3`
+		if _, err := tx.ExecContext(ctx, q, rewrapped, aead != nil, k.id); err != nil {
+			return errors.Wrapf(err, "updating key %d", k.id)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	ks.kek = aead
+
+	return nil
+}
+
+func newKEK(kek []byte) (cipher.AEAD, error) {
+	if kek == nil {
+		return nil, nil
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating master key cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, errors.Wrap(err, "creating master key AEAD")
+}
+
+// wrap encrypts k, the key with the given ID, with kek,
+// prepending the random nonce to the result.
+// The ID is the associated data,
+// so a wrapped key cannot be moved to another row.
+// If kek is nil,
+// k is returned unchanged.
+func wrap(kek cipher.AEAD, id int64, k []byte) ([]byte, error) {
+	if kek == nil {
+		return k, nil
+	}
+	nonce := make([]byte, kek.NonceSize(), kek.NonceSize()+len(k)+kek.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "generating nonce")
+	}
+	return kek.Seal(nonce, nonce, k, wrapData(id)), nil
+}
+
+// unwrap reverses wrap for the key with the given ID,
+// if it is wrapped.
+func (ks *KeyStore) unwrap(id int64, k []byte, wrapped bool) ([]byte, error) {
+	if !wrapped {
+		return k, nil
+	}
+	if ks.kek == nil {
+		return nil, errors.Wrapf(ErrNoMasterKey, "unwrapping key %d", id)
+	}
+	return unwrap(ks.kek, id, k)
+}
+
+// unwrap reverses wrap for the key with the given ID.
+func unwrap(kek cipher.AEAD, id int64, k []byte) ([]byte, error) {
+	n := kek.NonceSize()
+	if len(k) < n {
+		return nil, fmt.Errorf("wrapped key %d too short", id)
+	}
+	plain, err := kek.Open(nil, k[:n], k[n:], wrapData(id))
+	if err != nil {
+		return nil, errors.Wrapf(ErrWrongMasterKey, "unwrapping key %d", id)
+	}
+	return plain, nil
+}
+
+// wrapData is the associated data for wrapping the key with the given ID.
+func wrapData(id int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(id))
+}
@@ -0,0 +1,36 @@
+package keydb
+
+import "context"
+
+type tenantKey struct{}
+
+// WithTenant returns a context that selects the given tenant
+// for the operations of a [KeyStore]
+// (unless it was obtained with [KeyStore.ForTenant]).
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// Tenant returns the tenant selected in ctx with [WithTenant],
+// or "" if there is none.
+func Tenant(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// ForTenant returns a view of the keystore for the given tenant,
+// which is used regardless of the tenant in the context.
+// The view shares the database, the master key, and the version of ks.
+func (ks *KeyStore) ForTenant(tenant string) *KeyStore {
+	return &KeyStore{store: ks.store, fixedTenant: &tenant}
+}
+
+// tenant resolves the tenant for an operation:
+// the one given to ForTenant, if any,
+// otherwise the one in ctx.
+func (ks *KeyStore) tenant(ctx context.Context) string {
+	if ks.fixedTenant != nil {
+		return *ks.fixedTenant
+	}
+	return Tenant(ctx)
+}
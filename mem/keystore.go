@@ -0,0 +1,302 @@
+// Package mem provides an in-memory implementation of encid.KeyStore.
+package mem
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/bobg/errors"
+
+	"github.com/bobg/encid"
+)
+
+// KeyStore is an in-memory implementation of encid.KeyStore.
+// It is safe for concurrent use.
+//
+// Keys are random and live only as long as the KeyStore,
+// unless saved with [KeyStore.Export] and restored with [KeyStore.Import].
+type KeyStore struct {
+	newcipher func([]byte) (cipher.Block, error)
+
+	mu     sync.RWMutex
+	keys   map[int64]*key
+	nextID int64
+}
+
+type key struct {
+	Key
+	ciph cipher.Block
+}
+
+// Key is the exported form of a key in a KeyStore.
+// See [KeyStore.Export] and [KeyStore.Import].
+type Key struct {
+	ID      int64          `json:"id"`
+	Type    int            `json:"type"`
+	K       []byte         `json:"k"`
+	Version int            `json:"version"`
+	State   encid.KeyState `json:"state"`
+}
+
+var (
+	_ encid.KeyStore     = &KeyStore{}
+	_ encid.Versioner    = &KeyStore{}
+	_ encid.KeyVersioner = &KeyStore{}
+	_ encid.KeyEncoder   = &KeyStore{}
+)
+
+// New creates a new, empty in-memory keystore.
+// The newcipher function takes a key and returns a cipher for encrypting and decrypting.
+// If newcipher is nil, it defaults to [aes.NewCipher].
+func New(newcipher func([]byte) (cipher.Block, error)) *KeyStore {
+	if newcipher == nil {
+		newcipher = aes.NewCipher
+	}
+	return &KeyStore{
+		newcipher: newcipher,
+		keys:      make(map[int64]*key),
+		nextID:    1,
+	}
+}
+
+// DecoderByID implements [encid.KeyStore].
+// It returns [encid.ErrRevoked] for a key that has been revoked.
+func (ks *KeyStore) DecoderByID(_ context.Context, id int64) (int, func(dst, src []byte), error) {
+	k, err := ks.keyByID(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	return k.Type, k.ciph.Decrypt, nil
+}
+
+// EncoderByID implements [encid.KeyEncoder].
+// It returns [encid.ErrRevoked] for a key that has been revoked.
+func (ks *KeyStore) EncoderByID(_ context.Context, id int64) (int, func(dst, src []byte), error) {
+	k, err := ks.keyByID(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	return k.Type, k.ciph.Encrypt, nil
+}
+
+// keyByID looks up a key that has not been revoked.
+func (ks *KeyStore) keyByID(id int64) (*key, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	k, ok := ks.keys[id]
+	if !ok {
+		return nil, encid.ErrNotFound
+	}
+	if k.State == encid.KeyRevoked {
+		return nil, encid.ErrRevoked
+	}
+	return k, nil
+}
+
+// EncoderByType implements [encid.KeyStore].
+// It uses the latest active key of the given type.
+func (ks *KeyStore) EncoderByType(_ context.Context, typ int) (int64, func(dst, src []byte), error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	var found *key
+	for _, k := range ks.keys {
+		if k.Type != typ || k.State != encid.KeyActive {
+			continue
+		}
+		if found == nil || k.ID > found.ID {
+			found = k
+		}
+	}
+	if found == nil {
+		return 0, nil, encid.ErrNotFound
+	}
+	return found.ID, found.ciph.Encrypt, nil
+}
+
+// Version implements [encid.Versioner].
+// It is always 2.
+func (ks *KeyStore) Version() int {
+	return 2
+}
+
+// KeyVersion implements [encid.KeyVersioner].
+func (ks *KeyStore) KeyVersion(_ context.Context, id int64) (int, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	k, ok := ks.keys[id]
+	if !ok {
+		return 0, encid.ErrNotFound
+	}
+	return k.Version, nil
+}
+
+// NewKey adds a new random key of the given type and size to the keystore,
+// returning its ID.
+// The key's version is the version of the keystore.
+func (ks *KeyStore) NewKey(ctx context.Context, typ, keysize int) (int64, error) {
+	return ks.NewKeyVersion(ctx, typ, keysize, ks.Version())
+}
+
+// NewKeyVersion is like [KeyStore.NewKey]
+// but gives the new key the specified version
+// rather than the version of the keystore.
+// See [encid.KeyVersioner].
+func (ks *KeyStore) NewKeyVersion(_ context.Context, typ, keysize, version int) (int64, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	return ks.newKey(typ, keysize, version)
+}
+
+// newKey adds a new random key to the keystore.
+// The caller must hold a write lock.
+func (ks *KeyStore) newKey(typ, keysize, version int) (int64, error) {
+	if version < 1 {
+		return 0, fmt.Errorf("invalid key version %d", version)
+	}
+
+	k := make([]byte, keysize)
+	if _, err := rand.Read(k); err != nil {
+		return 0, errors.Wrap(err, "generating key")
+	}
+
+	ciph, err := ks.newcipher(k)
+	if err != nil {
+		return 0, errors.Wrap(err, "creating cipher")
+	}
+
+	id := ks.nextID
+	ks.add(&key{Key: Key{ID: id, Type: typ, K: k, Version: version}, ciph: ciph})
+
+	return id, nil
+}
+
+// add adds a key to the keystore.
+// The caller must hold a write lock.
+func (ks *KeyStore) add(k *key) {
+	ks.keys[k.ID] = k
+	if k.ID >= ks.nextID {
+		ks.nextID = k.ID + 1
+	}
+}
+
+// Rotate adds a new random key of the given type and size to the keystore,
+// returning its ID.
+// Other active keys of the same type become decode-only
+// (see [encid.KeyDecodeOnly]).
+func (ks *KeyStore) Rotate(_ context.Context, typ, keysize int) (int64, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	id, err := ks.newKey(typ, keysize, ks.Version())
+	if err != nil {
+		return 0, err
+	}
+
+	for _, k := range ks.keys {
+		if k.Type == typ && k.ID != id && k.State == encid.KeyActive {
+			k.State = encid.KeyDecodeOnly
+		}
+	}
+
+	return id, nil
+}
+
+// Retire makes the key with the given ID decode-only
+// (see [encid.KeyDecodeOnly]).
+// Retiring a revoked key leaves it revoked.
+func (ks *KeyStore) Retire(_ context.Context, id int64) error {
+	return ks.setState(id, encid.KeyDecodeOnly)
+}
+
+// Revoke revokes the key with the given ID
+// (see [encid.KeyRevoked]).
+// Strings encoded with it can no longer be decoded.
+func (ks *KeyStore) Revoke(_ context.Context, id int64) error {
+	return ks.setState(id, encid.KeyRevoked)
+}
+
+// setState advances the key with the given ID to the given state.
+// Keys never move back to an earlier state.
+func (ks *KeyStore) setState(id int64, state encid.KeyState) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	k, ok := ks.keys[id]
+	if !ok {
+		return encid.ErrNotFound
+	}
+	k.State = max(k.State, state)
+	return nil
+}
+
+// Export returns a copy of all the keys in the keystore,
+// in order of ID.
+// The result can be serialized (e.g. as JSON)
+// and later restored with [KeyStore.Import].
+//
+// The result contains raw key material,
+// so take care to store it securely.
+func (ks *KeyStore) Export() []Key {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	result := make([]Key, 0, len(ks.keys))
+	for _, k := range ks.keys {
+		kcopy := k.Key
+		kcopy.K = append([]byte(nil), k.K...)
+		result = append(result, kcopy)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+
+	return result
+}
+
+// Import adds keys to the keystore,
+// e.g. ones previously produced by [KeyStore.Export].
+// If any of them is invalid,
+// including by having an ID less than 1,
+// or has the same ID as another key,
+// none of them is added.
+// New keys subsequently created in the keystore have IDs greater than any imported one.
+func (ks *KeyStore) Import(keys []Key) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	toAdd := make(map[int64]*key)
+	for _, k := range keys {
+		if k.ID < 1 {
+			return fmt.Errorf("invalid key ID %d", k.ID)
+		}
+		if _, ok := ks.keys[k.ID]; ok {
+			return fmt.Errorf("duplicate key ID %d", k.ID)
+		}
+		if _, ok := toAdd[k.ID]; ok {
+			return fmt.Errorf("duplicate key ID %d", k.ID)
+		}
+		if k.Version < 1 {
+			return fmt.Errorf("invalid version %d for key %d", k.Version, k.ID)
+		}
+
+		k.K = append([]byte(nil), k.K...)
+		ciph, err := ks.newcipher(k.K)
+		if err != nil {
+			return errors.Wrapf(err, "creating cipher for key %d", k.ID)
+		}
+		toAdd[k.ID] = &key{Key: k, ciph: ciph}
+	}
+
+	for _, k := range toAdd {
+		ks.add(k)
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package mem
+
+import (
+	"context"
+	"crypto/aes"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/bobg/encid"
+	"github.com/bobg/encid/testutil"
+)
+
+func TestKeyStore(t *testing.T) {
+	ctx := context.Background()
+	ks := New(nil)
+
+	_, _, err := ks.DecoderByID(ctx, 1)
+	if !errors.Is(err, encid.ErrNotFound) {
+		t.Errorf("got %v, want %v", err, encid.ErrNotFound)
+	}
+
+	_, _, err = ks.EncoderByType(ctx, 1)
+	if !errors.Is(err, encid.ErrNotFound) {
+		t.Errorf("got %v, want %v", err, encid.ErrNotFound)
+	}
+
+	for typ := 1; typ <= 3; typ++ {
+		if _, err := ks.NewKey(ctx, typ, aes.BlockSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ks.NewKeyVersion(ctx, 4, aes.BlockSize, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.EncodeDecode(ctx, t, ks, 5)
+}
+
+func TestStates(t *testing.T) {
+	ctx := context.Background()
+	ks := New(nil)
+
+	id1, err := ks.NewKey(ctx, 1, aes.BlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, str, err := encid.Encode(ctx, ks, 1, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id2, err := ks.Rotate(ctx, 1, aes.BlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotID, _, err := ks.EncoderByType(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotID != id2 {
+		t.Errorf("after rotation, got key ID %d, want %d", gotID, id2)
+	}
+	if _, n, err := encid.Decode(ctx, ks, id1, str); err != nil || n != 17 {
+		t.Errorf("decoding with retired key: got (%d, %v), want 17", n, err)
+	}
+
+	if err := ks.Retire(ctx, id2); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ks.EncoderByType(ctx, 1); !errors.Is(err, encid.ErrNotFound) {
+		t.Errorf("with all keys retired, got error %v, want %v", err, encid.ErrNotFound)
+	}
+
+	if err := ks.Revoke(ctx, id1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := encid.Decode(ctx, ks, id1, str); !errors.Is(err, encid.ErrRevoked) {
+		t.Errorf("got error %v, want %v", err, encid.ErrRevoked)
+	}
+	if err := ks.Retire(ctx, id1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ks.DecoderByID(ctx, id1); !errors.Is(err, encid.ErrRevoked) {
+		t.Errorf("after retiring revoked key, got error %v, want %v", err, encid.ErrRevoked)
+	}
+
+	if err := ks.Revoke(ctx, 1000); !errors.Is(err, encid.ErrNotFound) {
+		t.Errorf("revoking nonexistent key, got error %v, want %v", err, encid.ErrNotFound)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	ks := New(nil)
+
+	if _, err := ks.NewKey(ctx, 1, aes.BlockSize); err != nil {
+		t.Fatal(err)
+	}
+	keyID, str, err := encid.Encode(ctx, ks, 1, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Rotate(ctx, 1, aes.BlockSize); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := json.Marshal(ks.Export())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []Key
+	if err := json.Unmarshal(j, &keys); err != nil {
+		t.Fatal(err)
+	}
+
+	ks2 := New(nil)
+	if err := ks2.Import(keys); err != nil {
+		t.Fatal(err)
+	}
+
+	typ, n, err := encid.Decode(ctx, ks2, keyID, str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != 1 || n != 17 {
+		t.Errorf("got (%d, %d), want (1, 17)", typ, n)
+	}
+
+	id, err := ks2.NewKey(ctx, 2, aes.BlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id <= keys[len(keys)-1].ID {
+		t.Errorf("got new key ID %d, want greater than %d", id, keys[len(keys)-1].ID)
+	}
+
+	if err := ks2.Import(keys[:1]); err == nil {
+		t.Error("importing duplicate key: got nil error")
+	}
+	if err := New(nil).Import([]Key{{ID: 1, Type: 1, K: []byte("bad key"), Version: 2}}); err == nil {
+		t.Error("importing bad key: got nil error")
+	}
+	if err := New(nil).Import([]Key{{ID: 0, Type: 1, K: make([]byte, 16), Version: 2}}); err == nil {
+		t.Error("importing key ID 0: got nil error")
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	ctx := context.Background()
+	ks := New(nil)
+
+	if _, err := ks.NewKey(ctx, 1, aes.BlockSize); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := ks.Rotate(ctx, 1, aes.BlockSize); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			keyID, str, err := encid.Encode(ctx, ks, 1, 17)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, n, err := encid.Decode(ctx, ks, keyID, str); err != nil || n != 17 {
+				t.Errorf("got (%d, %v), want 17", n, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,190 @@
+package encid
+
+import (
+	"context"
+	"crypto/aes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	"github.com/bobg/errors"
+)
+
+// Limits on the number of decimal digits in numeric encoding.
+// See [EncodeNumber].
+//
+// The minimum follows NIST SP 800-38G Rev. 1,
+// which requires a domain of at least a million values
+// for format-preserving encryption:
+// over smaller domains,
+// an attacker can tabulate the whole permutation
+// from a modest number of known pairs.
+const (
+	MinDigits = 6
+	MaxDigits = 18
+)
+
+// EncodeNumber encrypts n,
+// which must be in the range [0, 10^digits),
+// into another number in the same range,
+// using a key of the given type from the given keystore.
+// It returns the ID of the key used and the encrypted number.
+// Decode the result with [DecodeNumber],
+// giving the same number of digits.
+//
+// This is for systems that accept only digits in ID fields.
+// The number of digits must be between [MinDigits] and [MaxDigits].
+//
+// The encryption is format-preserving:
+// a Feistel network over decimal numbers,
+// like the FF1 construction of NIST SP 800-38G,
+// whose round function is the key's block cipher.
+// Since every number in the range decodes to some other number,
+// numeric encoding does not detect mistyped or forged inputs.
+//
+// Decoding requires a keystore that is a [KeyEncoder].
+func EncodeNumber(ctx context.Context, ks KeyStore, typ int, n int64, digits int) (int64, int64, error) {
+	limit, err := digitsLimit(digits)
+	if err != nil {
+		return 0, 0, err
+	}
+	if n < 0 || n >= limit {
+		return 0, 0, fmt.Errorf("number %d out of range for %d digits", n, digits)
+	}
+
+	keyID, enc, err := ks.EncoderByType(ctx, typ)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "getting key with type %d from keystore", typ)
+	}
+
+	return keyID, numericEncrypt(enc, n, digits), nil
+}
+
+// DecodeNumber decodes a keyID/number pair produced by [EncodeNumber]
+// with the same number of digits.
+// It produces the type of the key that was used, and the original number.
+func DecodeNumber(ctx context.Context, ks KeyStore, keyID, m int64, digits int) (int, int64, error) {
+	limit, err := digitsLimit(digits)
+	if err != nil {
+		return 0, 0, err
+	}
+	if m < 0 || m >= limit {
+		return 0, 0, errors.Wrapf(ErrInvalidToken, "number %d out of range for %d digits", m, digits)
+	}
+
+	typ, enc, err := encoderByID(ctx, ks, keyID)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "getting key with ID %d", keyID)
+	}
+
+	return typ, numericDecrypt(enc, m, digits), nil
+}
+
+// EncodeDigits is like [EncodeNumber]
+// but expresses the encrypted number as a string of exactly the given number of digits,
+// with leading zeroes as needed.
+// Decode the result with [DecodeDigits].
+func EncodeDigits(ctx context.Context, ks KeyStore, typ int, n int64, digits int) (int64, string, error) {
+	keyID, m, err := EncodeNumber(ctx, ks, typ, n, digits)
+	if err != nil {
+		return 0, "", err
+	}
+	return keyID, fmt.Sprintf("%0*d", digits, m), nil
+}
+
+// DecodeDigits decodes a keyID/string pair produced by [EncodeDigits].
+// The number of digits is the length of the string.
+// It produces the type of the key that was used, and the original number.
+func DecodeDigits(ctx context.Context, ks KeyStore, keyID int64, inp string) (int, int64, error) {
+	if len(inp) < MinDigits || len(inp) > MaxDigits {
+		return 0, 0, errors.Wrapf(ErrInvalidToken, "input has %d digits, want between %d and %d", len(inp), MinDigits, MaxDigits)
+	}
+	for _, c := range []byte(inp) {
+		if c < '0' || c > '9' {
+			return 0, 0, errors.Wrapf(ErrInvalidToken, "invalid digit %q", c)
+		}
+	}
+	m, err := strconv.ParseInt(inp, 10, 64)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "parsing %s", inp)
+	}
+	return DecodeNumber(ctx, ks, keyID, m, len(inp))
+}
+
+// digitsLimit returns 10^digits,
+// checking that digits is between MinDigits and MaxDigits.
+func digitsLimit(digits int) (int64, error) {
+	if digits < MinDigits || digits > MaxDigits {
+		return 0, fmt.Errorf("number of digits %d out of range [%d, %d]", digits, MinDigits, MaxDigits)
+	}
+	return pow10(digits), nil
+}
+
+func pow10(n int) int64 {
+	result := int64(1)
+	for range n {
+		result *= 10
+	}
+	return result
+}
+
+const (
+	// numericTag distinguishes the round-function inputs of numeric encoding
+	// from other blocks encrypted with the same key.
+	numericTag = 'n'
+
+	// numericRounds is the number of rounds in the Feistel network for numeric encoding.
+	numericRounds = 10
+)
+
+// numericEncrypt encrypts n, which has the given number of digits.
+// The number is split into a high part of digits/2 digits
+// and a low part of the remaining digits,
+// which are combined in each round
+// by adding the round function of one to the other,
+// modulo the power of ten for its size.
+func numericEncrypt(enc func(dst, src []byte), n int64, digits int) int64 {
+	u, v := digits/2, digits-digits/2
+	a, b := n/pow10(v), n%pow10(v)
+	for i := range numericRounds {
+		m := pow10(numericHalf(i, u, v))
+		c := (a + numericRound(enc, digits, i, b, m)) % m
+		a, b = b, c
+	}
+	return a*pow10(v) + b
+}
+
+// numericDecrypt reverses numericEncrypt.
+func numericDecrypt(enc func(dst, src []byte), n int64, digits int) int64 {
+	u, v := digits/2, digits-digits/2
+	a, b := n/pow10(v), n%pow10(v)
+	for i := numericRounds - 1; i >= 0; i-- {
+		m := pow10(numericHalf(i, u, v))
+		c := b
+		b = a
+		a = (c - numericRound(enc, digits, i, b, m) + m) % m
+	}
+	return a*pow10(v) + b
+}
+
+// numericHalf tells the number of digits in the part of the number
+// that is modified in the given round.
+func numericHalf(round, u, v int) int {
+	if round%2 == 0 {
+		return u
+	}
+	return v
+}
+
+// numericRound is the round function for numeric encoding.
+// It encrypts a block containing the number of digits, the round number, and x,
+// and returns the result modulo m.
+func numericRound(enc func(dst, src []byte), digits, round int, x, m int64) int64 {
+	var buf [aes.BlockSize]byte
+	buf[0] = numericTag
+	buf[1] = byte(digits)
+	buf[2] = byte(round)
+	binary.BigEndian.PutUint64(buf[8:], uint64(x))
+	enc(buf[:], buf[:])
+	return int64(binary.BigEndian.Uint64(buf[:8]) % uint64(m))
+}
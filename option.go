@@ -0,0 +1,198 @@
+package encid
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/bobg/basexx/v2"
+)
+
+// Option is an option for encoding and decoding.
+// Pass options to [NewCodec],
+// or to functions such as [Encode] and [Decode].
+type Option func(*config)
+
+type config struct {
+	ks      KeyStore
+	base    basexx.Base
+	version int
+	rand    io.Reader
+	padding bool
+
+	// checkChar means encoded strings end with a check character.
+	// See WithCheckChar.
+	checkChar bool
+
+	// groupSize is the size of hyphen-separated groups in encoded strings.
+	// See WithGroups.
+	groupSize int
+
+	blockOpts
+
+	// prefixes maps key types to their prefixes.
+	// See WithPrefix.
+	prefixes map[int]string
+
+	// types are the expected types for decoding.
+	// See WithTypes.
+	types []int
+}
+
+// newConfig applies opts to a default config.
+// If ks is not nil,
+// it overrides any [WithKeyStore] option.
+func newConfig(ks KeyStore, opts []Option) *config {
+	c := &config{
+		base: basexx.Base30,
+		rand: rand.Reader,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if ks != nil {
+		c.ks = ks
+	}
+	if c.version > 0 && c.ks != nil {
+		c.ks = versionKeyStore{KeyStore: c.ks, version: c.version}
+	}
+	return c
+}
+
+// normalize prepares an input string for decoding.
+// Base 30 strings are mapped to lowercase as a convenience.
+// Other bases are left alone
+// (the alphabets in this package handle case-insensitivity themselves where appropriate),
+// except that in all bases,
+// characters easily confused with 0 and 1
+// (O and o, and I, i, L, and l)
+// are mapped to those digits
+// when they are not themselves digits of the base.
+// Whitespace is removed,
+// and so are hyphens when they are not digits of the base
+// (see [WithGroups]).
+func (c *config) normalize(inp string) string {
+	if c.base == basexx.Base30 {
+		inp = strings.ToLower(inp)
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		if r >= 0x80 {
+			return r
+		}
+		if _, err := c.base.Decode(byte(r)); err == nil {
+			return r
+		}
+		var digit byte
+		switch r {
+		case '-':
+			return -1
+		case 'O', 'o':
+			digit = '0'
+		case 'I', 'i', 'L', 'l':
+			digit = '1'
+		default:
+			return r
+		}
+		if _, err := c.base.Decode(digit); err != nil {
+			return r
+		}
+		return rune(digit)
+	}, inp)
+}
+
+// WithBase is an option that causes encrypted strings to be expressed in the given base
+// (and decoded from it).
+// The default is [basexx.Base30].
+// Besides the bases in the basexx package,
+// this package provides [Crockford32], [Base58], [Base64URL], and [Hex].
+func WithBase(base basexx.Base) Option {
+	return func(c *config) {
+		c.base = base
+	}
+}
+
+var (
+	// Crockford32 is Douglas Crockford's base 32,
+	// which uses digits 0-9, then upper-case ABCDEFGHJKMNPQRSTVWXYZ.
+	// Decoding is case-insensitive
+	// and maps I and L to 1 and O to 0.
+	// See https://www.crockford.com/base32.html.
+	Crockford32 basexx.Base = &alphabet{
+		digits: "0123456789ABCDEFGHJKMNPQRSTVWXYZ",
+		fold:   foldCrockford,
+	}
+
+	// Base58 is base 58 using the Bitcoin alphabet,
+	// which excludes 0, O, I, and l.
+	Base58 basexx.Base = &alphabet{
+		digits: "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz",
+	}
+
+	// Base64URL is base 64 using the URL- and filename-safe alphabet of RFC 4648,
+	// which uses A-Z, a-z, 0-9, "-", and "_".
+	Base64URL basexx.Base = &alphabet{
+		digits: "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_",
+	}
+
+	// Hex is base 16 using digits 0-9, then lower-case abcdef.
+	// Decoding is case-insensitive.
+	Hex basexx.Base = &alphabet{
+		digits: "0123456789abcdef",
+		fold:   foldLower,
+	}
+)
+
+// alphabet is an implementation of [basexx.Base]
+// in which digit values are the positions of bytes in a string.
+type alphabet struct {
+	digits string
+
+	// fold, if not nil, maps an input byte to the canonical form in digits.
+	fold func(byte) byte
+}
+
+func (a *alphabet) N() int64 {
+	return int64(len(a.digits))
+}
+
+func (a *alphabet) Encode(val int64) (byte, error) {
+	if val < 0 || val >= int64(len(a.digits)) {
+		return 0, fmt.Errorf("value %d out of range for base %d: %w", val, len(a.digits), basexx.ErrInvalid)
+	}
+	return a.digits[val], nil
+}
+
+func (a *alphabet) Decode(digit byte) (int64, error) {
+	if a.fold != nil {
+		digit = a.fold(digit)
+	}
+	if i := strings.IndexByte(a.digits, digit); i >= 0 {
+		return int64(i), nil
+	}
+	return 0, fmt.Errorf("invalid digit %q for base %d: %w", digit, len(a.digits), basexx.ErrInvalid)
+}
+
+func foldLower(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+func foldCrockford(b byte) byte {
+	if b >= 'a' && b <= 'z' {
+		b -= 'a' - 'A'
+	}
+	switch b {
+	case 'I', 'L':
+		return '1'
+	case 'O':
+		return '0'
+	}
+	return b
+}
@@ -0,0 +1,70 @@
+package encid
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/bobg/basexx/v2"
+)
+
+// WithPadding is an option that causes encoded strings
+// to be left-padded with the zero digit of the base
+// to the maximum length for their kind
+// (see [MaxLen]),
+// so that all strings produced with the same options have the same length.
+//
+// Decoding accepts both padded and unpadded strings,
+// with or without this option.
+func WithPadding() Option {
+	return func(c *config) {
+		c.padding = true
+	}
+}
+
+// MaxLen tells the maximum length of a string in the given base
+// that encodes a value of nbytes bytes.
+// The strings produced by [Encode] encode [crypto/aes.BlockSize] (16) bytes,
+// and tokens produced by [EncodeToken] encode 24 bytes,
+// except in the short format (see [WithShortFormat]),
+// where they encode 8 and 16 bytes respectively.
+func MaxLen(base basexx.Base, nbytes int) int {
+	var (
+		limit = new(big.Int).Lsh(big.NewInt(1), uint(8*nbytes))
+		n     = big.NewInt(base.N())
+		v     = big.NewInt(1)
+		l     int
+	)
+	for v.Cmp(limit) < 0 {
+		v.Mul(v, n)
+		l++
+	}
+	return l
+}
+
+// pad left-pads s, which encodes nbytes bytes,
+// if the padding option is set.
+func (c *config) pad(s string, nbytes int) (string, error) {
+	if !c.padding {
+		return s, nil
+	}
+	zero, err := c.base.Encode(0)
+	if err != nil {
+		return "", err
+	}
+	if n := MaxLen(c.base, nbytes) - len(s); n > 0 {
+		s = strings.Repeat(string(zero), n) + s
+	}
+	return s, nil
+}
+
+// trimZeros removes leading zero digits from s,
+// undoing any padding.
+func trimZeros(s string, base basexx.Base) string {
+	for len(s) > 0 {
+		if v, err := base.Decode(s[0]); err != nil || v != 0 {
+			break
+		}
+		s = s[1:]
+	}
+	return s
+}
@@ -0,0 +1,190 @@
+package postgres
+
+import (
+	"context"
+	"crypto/cipher"
+	"database/sql"
+	"embed"
+	"io/fs"
+
+	"github.com/bobg/errors"
+	_ "github.com/lib/pq"
+	"github.com/pressly/goose/v3"
+
+	"github.com/bobg/encid"
+	"github.com/bobg/encid/internal/keydb"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// New creates a new PostgreSQL-backed keystore
+// using the database described by dsn,
+// a connection string as understood by [github.com/lib/pq].
+// The newcipher function takes a key and returns a cipher for encrypting and decrypting.
+// If newcipher is nil, it defaults to [aes.NewCipher].
+//
+// The schema and semantics are the same as for the SQLite-backed keystore
+// in [github.com/bobg/encid/sqlite],
+// including tenants (see [WithTenant]).
+// If the keystore is new (i.e., contains no keys),
+// the version number of the keystore is set to 2.
+func New(ctx context.Context, dsn string, newcipher func([]byte) (cipher.Block, error)) (*KeyStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening database")
+	}
+
+	mfs, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return nil, errors.Wrap(err, "getting migrations")
+	}
+
+	provider, err := goose.NewProvider(goose.DialectPostgres, db, mfs, goose.WithVerbose(false))
+	if err != nil {
+		return nil, errors.Wrap(err, "creating goose provider")
+	}
+	if _, err := provider.Up(ctx); err != nil {
+		return nil, errors.Wrap(err, "running migrations")
+	}
+
+	keys, err := keydb.New(ctx, db, newcipher)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyStore{keys: keys}, nil
+}
+
+// KeyStore is an implementation of encid.KeyStore backed by a PostgreSQL database.
+//
+// A KeyStore may hold the keys of many tenants,
+// each in its own namespace.
+// See [WithTenant] and [KeyStore.ForTenant].
+type KeyStore struct {
+	keys *keydb.KeyStore
+}
+
+var (
+	_ encid.KeyStore          = &KeyStore{}
+	_ encid.Versioner         = &KeyStore{}
+	_ encid.KeyVersioner      = &KeyStore{}
+	_ encid.DecoderBatcher    = &KeyStore{}
+	_ encid.KeyEncoder        = &KeyStore{}
+	_ encid.VersionedKeyStore = &KeyStore{}
+)
+
+// Close closes the keystore's database handle.
+func (ks *KeyStore) Close() error {
+	return ks.keys.DB().Close()
+}
+
+// DecoderByID implements [encid.KeyStore].
+// It returns [encid.ErrRevoked] for a key that has been revoked.
+func (ks *KeyStore) DecoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), error) {
+	return ks.keys.DecoderByID(ctx, id)
+}
+
+// VersionedDecoderByID implements [encid.VersionedKeyStore].
+// It is like [KeyStore.DecoderByID]
+// but also returns the key's version,
+// from the same query.
+func (ks *KeyStore) VersionedDecoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), int, error) {
+	return ks.keys.VersionedDecoderByID(ctx, id)
+}
+
+// EncoderByID implements [encid.KeyEncoder].
+// It returns [encid.ErrRevoked] for a key that has been revoked.
+func (ks *KeyStore) EncoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), error) {
+	return ks.keys.EncoderByID(ctx, id)
+}
+
+// DecodersByID implements [encid.DecoderBatcher].
+// It retrieves the requested keys a few hundred at a time,
+// in one query per batch.
+func (ks *KeyStore) DecodersByID(ctx context.Context, ids []int64) (map[int64]encid.Decoder, error) {
+	return ks.keys.DecodersByID(ctx, ids)
+}
+
+// EncoderByType implements [encid.KeyStore].
+// It uses the latest active key of the given type.
+func (ks *KeyStore) EncoderByType(ctx context.Context, typ int) (int64, func(dst, src []byte), error) {
+	return ks.keys.EncoderByType(ctx, typ)
+}
+
+// VersionedEncoderByType implements [encid.VersionedKeyStore].
+// It is like [KeyStore.EncoderByType]
+// but also returns the key's version,
+// from the same query.
+func (ks *KeyStore) VersionedEncoderByType(ctx context.Context, typ int) (int64, func(dst, src []byte), int, error) {
+	return ks.keys.VersionedEncoderByType(ctx, typ)
+}
+
+// HasKeys tells whether the keystore has any keys of the given type,
+// in any state,
+// as opposed to only retired or revoked ones
+// (in which case [KeyStore.EncoderByType] fails too).
+func (ks *KeyStore) HasKeys(ctx context.Context, typ int) (bool, error) {
+	return ks.keys.HasKeys(ctx, typ)
+}
+
+func (ks *KeyStore) Version() int {
+	return ks.keys.Version()
+}
+
+// KeyVersion implements [encid.KeyVersioner].
+// Each key's version is the version of the keystore at the time the key was created.
+func (ks *KeyStore) KeyVersion(ctx context.Context, id int64) (int, error) {
+	return ks.keys.KeyVersion(ctx, id)
+}
+
+// NewKey adds a new random key of the given type and size to the keystore,
+// returning its ID.
+// The key's version is the version of the keystore.
+func (ks *KeyStore) NewKey(ctx context.Context, typ, keysize int) (int64, error) {
+	return ks.keys.NewKey(ctx, typ, keysize)
+}
+
+// NewKeyVersion is like [KeyStore.NewKey]
+// but gives the new key the specified version
+// rather than the version of the keystore.
+// See [encid.KeyVersioner].
+func (ks *KeyStore) NewKeyVersion(ctx context.Context, typ, keysize, version int) (int64, error) {
+	return ks.keys.NewKeyVersion(ctx, typ, keysize, version)
+}
+
+// Migrate brings the keystore up to version 2.
+//
+// Existing keys keep their version,
+// so strings encoded with version 1 keys continue to decode.
+// For each tenant and type whose latest active key is at version 1,
+// a new version 2 key is added,
+// of the same size,
+// so that subsequent encoding of that type uses version 2.
+//
+// Migrating a keystore that is already at version 2 is a no-op.
+func (ks *KeyStore) Migrate(ctx context.Context) error {
+	return ks.keys.Migrate(ctx)
+}
+
+// Rotate adds a new random key of the given type and size to the keystore,
+// returning its ID.
+// Other active keys of the same type become decode-only
+// (see [encid.KeyDecodeOnly]).
+func (ks *KeyStore) Rotate(ctx context.Context, typ, keysize int) (int64, error) {
+	return ks.keys.Rotate(ctx, typ, keysize)
+}
+
+// Retire makes the key with the given ID decode-only
+// (see [encid.KeyDecodeOnly]).
+// Retiring a revoked key leaves it revoked.
+func (ks *KeyStore) Retire(ctx context.Context, id int64) error {
+	return ks.keys.Retire(ctx, id)
+}
+
+// Revoke revokes the key with the given ID
+// (see [encid.KeyRevoked]).
+// Strings encoded with it can no longer be decoded.
+func (ks *KeyStore) Revoke(ctx context.Context, id int64) error {
+	return ks.keys.Revoke(ctx, id)
+}
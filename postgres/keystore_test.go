@@ -0,0 +1,247 @@
+package postgres
+
+import (
+	"context"
+	"crypto/aes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bobg/encid"
+	"github.com/bobg/encid/testutil"
+)
+
+// dsn is the connection string for the test server,
+// or "" if there is none.
+// It is set by TestMain.
+var dsn string
+
+// skipReason says why dsn is empty.
+var skipReason string
+
+// TestMain uses the server in $ENCID_TEST_POSTGRES, if set.
+// Otherwise it tries to start a temporary local server,
+// if the PostgreSQL binaries are available.
+func TestMain(m *testing.M) {
+	os.Exit(testMain(m))
+}
+
+func testMain(m *testing.M) int {
+	if dsn = os.Getenv("ENCID_TEST_POSTGRES"); dsn != "" {
+		return m.Run()
+	}
+
+	stop, err := startServer()
+	if err != nil {
+		skipReason = err.Error()
+	} else {
+		defer stop()
+	}
+
+	return m.Run()
+}
+
+func startServer() (func(), error) {
+	initdb, err := findBinary("initdb")
+	if err != nil {
+		return nil, err
+	}
+	pgctl, err := findBinary("pg_ctl")
+	if err != nil {
+		return nil, err
+	}
+
+	tmpdir, err := os.MkdirTemp("", "encid_postgres_test")
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		datadir = filepath.Join(tmpdir, "data")
+		logfile = filepath.Join(tmpdir, "log")
+	)
+
+	cmd := exec.Command(initdb, "-D", datadir, "-U", "postgres", "--auth=trust")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(tmpdir)
+		return nil, fmt.Errorf("running initdb: %w\n%s", err, out)
+	}
+
+	// Listen only on a Unix-domain socket in tmpdir.
+	opts := fmt.Sprintf("-k %s -h ''", tmpdir)
+	cmd = exec.Command(pgctl, "-D", datadir, "-l", logfile, "-o", opts, "-w", "start")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(tmpdir)
+		return nil, fmt.Errorf("starting server: %w\n%s", err, out)
+	}
+
+	dsn = fmt.Sprintf("host=%s user=postgres dbname=postgres sslmode=disable", tmpdir)
+
+	return func() {
+		exec.Command(pgctl, "-D", datadir, "-m", "fast", "-w", "stop").Run()
+		os.RemoveAll(tmpdir)
+	}, nil
+}
+
+func findBinary(name string) (string, error) {
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	matches, _ := filepath.Glob(filepath.Join("/usr/lib/postgresql/*/bin", name))
+	if len(matches) > 0 {
+		return matches[len(matches)-1], nil
+	}
+	return "", fmt.Errorf("%s not found and ENCID_TEST_POSTGRES not set", name)
+}
+
+// newSchema creates a new, empty schema in the test database
+// and returns a connection string that uses it.
+func newSchema(t *testing.T) string {
+	t.Helper()
+
+	if dsn == "" {
+		t.Skipf("no PostgreSQL server: %s", skipReason)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	schema := fmt.Sprintf("encid_test_%d", os.Getpid())
+	schema += "_" + strings.ToLower(strings.NewReplacer("/", "_", "#", "_").Replace(t.Name()))
+
+	if _, err := db.Exec(fmt.Sprintf(`DROP SCHEMA IF EXISTS %q CASCADE`, schema)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(fmt.Sprintf(`CREATE SCHEMA %q`, schema)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			return
+		}
+		defer db.Close()
+		db.Exec(fmt.Sprintf(`DROP SCHEMA IF EXISTS %q CASCADE`, schema))
+	})
+
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	return fmt.Sprintf("%s search_path=%s", dsn, schema)
+}
+
+func TestSuite(t *testing.T) {
+	ctx := context.Background()
+
+	testutil.KeyStoreSuite(ctx, t, func(t *testing.T) (testutil.DBKeyStore, func(*testing.T) testutil.DBKeyStore) {
+		schemaDSN := newSchema(t)
+
+		open := func(t *testing.T) testutil.DBKeyStore {
+			ks, err := New(ctx, schemaDSN, aes.NewCipher)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { ks.Close() })
+			return ks
+		}
+
+		return open(t), open
+	})
+}
+
+func TestTenants(t *testing.T) {
+	ctx := context.Background()
+
+	ks, err := New(ctx, newSchema(t), aes.NewCipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.Close()
+
+	var (
+		ctxA = WithTenant(ctx, "a")
+		ctxB = WithTenant(ctx, "b")
+	)
+
+	idA, err := ks.NewKey(ctxA, 1, aes.BlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idB, err := ks.ForTenant("b").NewKey(ctx, 1, aes.BlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each tenant encodes with its own key.
+	for _, c := range []struct {
+		ctx  context.Context
+		want int64
+	}{{ctxA, idA}, {ctxB, idB}} {
+		if id, _, err := ks.EncoderByType(c.ctx, 1); err != nil || id != c.want {
+			t.Errorf("tenant %q: got key (%d, %v), want %d", Tenant(c.ctx), id, err, c.want)
+		}
+	}
+	if _, _, err := ks.EncoderByType(ctx, 1); !errors.Is(err, encid.ErrNotFound) {
+		t.Errorf("default tenant: got error %v, want %v", err, encid.ErrNotFound)
+	}
+
+	keyID, str, err := encid.Encode(ctxA, ks, 1, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, n, err := encid.Decode(ctxA, ks, keyID, str); err != nil || n != 17 {
+		t.Errorf("decoding in tenant a: got (%d, %v), want 17", n, err)
+	}
+	if _, _, err := encid.Decode(ctxB, ks, keyID, str); !errors.Is(err, encid.ErrNotFound) {
+		t.Errorf("decoding in tenant b: got error %v, want %v", err, encid.ErrNotFound)
+	}
+
+	// The tenant given to ForTenant overrides the context.
+	if _, n, err := encid.Decode(ctxB, ks.ForTenant("a"), keyID, str); err != nil || n != 17 {
+		t.Errorf("decoding with ForTenant: got (%d, %v), want 17", n, err)
+	}
+
+	// Keys are revoked and rotated per tenant.
+	if err := ks.Revoke(ctxB, idA); !errors.Is(err, encid.ErrNotFound) {
+		t.Errorf("revoking tenant a's key in tenant b: got error %v, want %v", err, encid.ErrNotFound)
+	}
+	if err := ks.Revoke(ctxB, idB); err != nil {
+		t.Fatal(err)
+	}
+	if _, n, err := encid.Decode(ctxA, ks, keyID, str); err != nil || n != 17 {
+		t.Errorf("decoding in tenant a after revoking in b: got (%d, %v), want 17", n, err)
+	}
+
+	newA, err := ks.Rotate(ctxA, 1, aes.BlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _, err := ks.EncoderByType(ctxA, 1); err != nil || id != newA {
+		t.Errorf("after rotation: got key (%d, %v), want %d", id, err, newA)
+	}
+	if _, _, err := ks.EncoderByType(ctxB, 1); !errors.Is(err, encid.ErrNotFound) {
+		t.Errorf("tenant b after rotating a: got error %v, want %v", err, encid.ErrNotFound)
+	}
+
+	results := encid.DecodeBatch(ctxB, ks, []encid.Encoded{{KeyID: keyID, Str: str}})
+	if !errors.Is(results[0].Err, encid.ErrNotFound) {
+		t.Errorf("batch decoding in tenant b: got error %v, want %v", results[0].Err, encid.ErrNotFound)
+	}
+}
@@ -0,0 +1,70 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/bobg/encid/internal/keydb"
+)
+
+// ErrNoMasterKey is the error produced when using a wrapped key
+// in a keystore that has no master key.
+// See [KeyStore.SetMasterKey].
+var ErrNoMasterKey = keydb.ErrNoMasterKey
+
+// ErrWrongMasterKey is the error produced when a wrapped key
+// cannot be unwrapped with the master key,
+// e.g. because the master key is not the one it was wrapped with.
+// See [KeyStore.SetMasterKey].
+var ErrWrongMasterKey = keydb.ErrWrongMasterKey
+
+// SetMasterKey sets the master key for this handle on the keystore.
+// The master key is a key-encryption key:
+// while it is set,
+// new keys are stored in the database "wrapped" (encrypted) with it,
+// and unwrapped in memory when needed.
+// This way,
+// a database user with read access to the keys table,
+// or anyone holding a dump or backup of the database,
+// cannot decrypt any IDs.
+// The master key itself is never stored in the database,
+// so every process using the keystore must set it.
+//
+// The master key must be 16, 24, or 32 bytes long.
+// It can be derived from a passphrase with [KeyStore.PassphraseKey].
+// A nil master key clears it.
+//
+// As in [github.com/bobg/encid/sqlite.KeyStore.SetMasterKey],
+// keys stored before a master key was set
+// remain unwrapped until [KeyStore.Rewrap] is called,
+// using a wrapped key without a master key produces an error wrapping [ErrNoMasterKey],
+// and a master key that does not unwrap the keys already in the database
+// produces an error wrapping [ErrWrongMasterKey].
+//
+// SetMasterKey must not be called concurrently with other methods on ks.
+func (ks *KeyStore) SetMasterKey(ctx context.Context, kek []byte) error {
+	return ks.keys.SetMasterKey(ctx, kek)
+}
+
+// PassphraseKey derives a master key from a passphrase,
+// suitable for [KeyStore.SetMasterKey] and [KeyStore.Rewrap].
+// It uses scrypt with a random salt that is stored in the database,
+// created by whichever process calls PassphraseKey first,
+// so all processes sharing the database derive the same master key.
+func (ks *KeyStore) PassphraseKey(ctx context.Context, passphrase []byte) ([]byte, error) {
+	return ks.keys.PassphraseKey(ctx, passphrase)
+}
+
+// Rewrap re-encrypts all the keys in the database, of every tenant, under a new master key,
+// in a single transaction,
+// and makes that the master key of this handle.
+// Other processes using the same database must then switch to the new master key too.
+// See [KeyStore.SetMasterKey].
+//
+// Keys that are currently wrapped must be wrapped with the current master key.
+// If kek is nil,
+// all keys are stored unwrapped.
+//
+// Rewrap must not be called concurrently with other methods on ks.
+func (ks *KeyStore) Rewrap(ctx context.Context, kek []byte) error {
+	return ks.keys.Rewrap(ctx, kek)
+}
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS keys (
+  id BIGSERIAL NOT NULL PRIMARY KEY,
+  typ INTEGER NOT NULL,
+  k BYTEA NOT NULL,
+  version INTEGER NOT NULL DEFAULT 1,
+  state INTEGER NOT NULL DEFAULT 0,
+  wrapped BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS keys_typ_index ON keys (typ);
+
+CREATE TABLE IF NOT EXISTS version (
+  singleton INTEGER NOT NULL PRIMARY KEY,
+  version INTEGER NOT NULL,
+  CHECK (singleton = 0)
+);
+
+INSERT INTO version (singleton, version) VALUES (0, 1) ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS kdf (
+  singleton INTEGER NOT NULL PRIMARY KEY,
+  salt BYTEA NOT NULL,
+  CHECK (singleton = 0)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS kdf;
+DROP TABLE IF EXISTS version;
+DROP TABLE IF EXISTS keys;
+-- +goose StatementEnd
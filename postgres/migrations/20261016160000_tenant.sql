@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE keys ADD COLUMN IF NOT EXISTS tenant TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS keys_tenant_typ_index ON keys (tenant, typ);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS keys_tenant_typ_index;
+
+ALTER TABLE keys DROP COLUMN IF EXISTS tenant;
+-- +goose StatementEnd
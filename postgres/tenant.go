@@ -0,0 +1,36 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/bobg/encid/internal/keydb"
+)
+
+// WithTenant returns a context that selects the given tenant
+// for the operations of a [KeyStore]
+// (unless it was obtained with [KeyStore.ForTenant]).
+//
+// Each tenant has its own namespace of keys.
+// Keys are created, rotated, looked up, retired, and revoked
+// only within the selected tenant;
+// the keys of other tenants are invisible to it.
+// Without a tenant,
+// the default tenant, "", is used.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return keydb.WithTenant(ctx, tenant)
+}
+
+// Tenant returns the tenant selected in ctx with [WithTenant],
+// or "" if there is none.
+func Tenant(ctx context.Context) string {
+	return keydb.Tenant(ctx)
+}
+
+// ForTenant returns a view of the keystore for the given tenant,
+// which is used regardless of the tenant in the context.
+// See [WithTenant].
+//
+// The view shares the database, the master key, and the version of ks.
+func (ks *KeyStore) ForTenant(tenant string) *KeyStore {
+	return &KeyStore{keys: ks.keys.ForTenant(tenant)}
+}
@@ -0,0 +1,73 @@
+package encid
+
+import (
+	"strings"
+
+	"github.com/bobg/errors"
+)
+
+// WithPrefix is an option that registers a human-readable prefix,
+// such as "usr_",
+// for strings encoded with keys of the given type.
+// Give the option once for each type that has a prefix.
+//
+// Encoding adds the prefix for the key's type.
+// Decoding removes a registered prefix
+// and checks that it belongs to the type of the key,
+// producing a [*WrongTypeError] if it doesn't.
+// It is also an error for the prefix to be missing
+// when the key's type has one.
+//
+// The prefix is not part of the encrypted string
+// and is compared case-sensitively.
+// Prefixes should end with a character that is not a digit of the base,
+// such as "_",
+// so that they cannot be confused with the encrypted string.
+func WithPrefix(typ int, prefix string) Option {
+	return func(c *config) {
+		if c.prefixes == nil {
+			c.prefixes = make(map[int]string)
+		}
+		c.prefixes[typ] = prefix
+	}
+}
+
+// splitPrefix finds the longest registered prefix that begins s,
+// after removing any leading and trailing whitespace.
+// It returns the type that the prefix belongs to,
+// the remainder of s,
+// and true.
+// If no registered prefix begins s,
+// it returns the trimmed s and false.
+func (c *config) splitPrefix(s string) (int, string, bool) {
+	s = strings.TrimSpace(s)
+
+	var (
+		typ   int
+		found string
+	)
+	for t, prefix := range c.prefixes {
+		if prefix != "" && len(prefix) > len(found) && strings.HasPrefix(s, prefix) {
+			typ, found = t, prefix
+		}
+	}
+	if found == "" {
+		return 0, s, false
+	}
+	return typ, s[len(found):], true
+}
+
+// checkPrefix checks that the prefix found by splitPrefix, if any,
+// belongs to typ, the type of the key used in decoding.
+func (c *config) checkPrefix(typ, prefixTyp int, hasPrefix bool) error {
+	if hasPrefix {
+		if typ != prefixTyp {
+			return wrongPrefix(c.prefixes[prefixTyp], typ, prefixTyp)
+		}
+		return nil
+	}
+	if prefix := c.prefixes[typ]; prefix != "" {
+		return errors.Wrapf(ErrWrongType, "missing prefix %q for type %d", prefix, typ)
+	}
+	return nil
+}
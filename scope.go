@@ -0,0 +1,44 @@
+package encid
+
+import (
+	"crypto/aes"
+	"crypto/sha256"
+)
+
+// WithScope is an option that binds encoded strings and tokens to a scope,
+// such as a tenant or user ID.
+// The scope is mixed into the whole encrypted block as a tweak,
+// so an input encoded with one scope
+// decrypts to garbage with any other
+// (or with none),
+// producing an error wrapping [ErrInvalidToken].
+// This lets one keystore serve many tenants
+// without a token from one being usable in another.
+//
+// An empty scope is the same as none.
+// Scopes require keys with encoding version 2
+// (see [Versioner] and [KeyVersioner]).
+// A wrong scope is caught as reliably as any other mistyped or forged input,
+// including when combined with [WithExpiry].
+func WithScope(scope []byte) Option {
+	return func(c *config) {
+		c.scope = nil
+		if len(scope) > 0 {
+			h := sha256.Sum256(scope)
+			c.scope = h[:aes.BlockSize]
+		}
+	}
+}
+
+// applyScope mixes the scope in bo, if any, into buf,
+// a version-2 block,
+// by XORing a hash of it into the whole block.
+// This is done both before and after the cipher,
+// so that changing the scope changes what the cipher sees.
+// Since XOR is its own inverse,
+// this is used both in encoding and in decoding.
+func (bo blockOpts) applyScope(buf *[aes.BlockSize]byte) {
+	for i, b := range bo.scope {
+		buf[i] ^= b
+	}
+}
@@ -2,9 +2,7 @@ package sqlite
 
 import (
 	"context"
-	"crypto/aes"
 	"crypto/cipher"
-	"crypto/rand"
 	"database/sql"
 	"embed"
 	"io/fs"
@@ -14,6 +12,7 @@ import (
 	"github.com/pressly/goose/v3"
 
 	"github.com/bobg/encid"
+	"github.com/bobg/encid/internal/keydb"
 )
 
 //go:embed migrations/*.sql
@@ -29,6 +28,7 @@ var migrations embed.FS
 // its version will be 1.
 // The version number controls whether the resulting encoded ids include a checksum.
 // Version 1 ids are not compatible with version 2 ids.
+// A version 1 keystore can be brought up to version 2 with [KeyStore.Migrate].
 func New(ctx context.Context, filename string, newcipher func([]byte) (cipher.Block, error)) (*KeyStore, error) {
 	db, err := sql.Open("sqlite3", filename)
 	if err != nil {
@@ -48,104 +48,138 @@ func New(ctx context.Context, filename string, newcipher func([]byte) (cipher.Bl
 		return nil, errors.Wrap(err, "running migrations")
 	}
 
-	var nkeys int
-	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM keys`).Scan(&nkeys); err != nil {
-		return nil, errors.Wrap(err, "counting keys")
-	}
-	if nkeys == 0 {
-		const q = `UPDATE version SET version = 2 WHERE singleton = 0 AND version < 2`
-		if _, err := db.ExecContext(ctx, q); err != nil {
-			return nil, errors.Wrap(err, "updating version")
-		}
-	}
-
-	var version int
-	err = db.QueryRowContext(ctx, `SELECT version FROM version WHERE singleton = 0`).Scan(&version)
+	keys, err := keydb.New(ctx, db, newcipher)
 	if err != nil {
-		return nil, errors.Wrap(err, "getting version")
-	}
-
-	if newcipher == nil {
-		newcipher = aes.NewCipher
+		return nil, err
 	}
 
-	return &KeyStore{
-		db:        db,
-		newcipher: newcipher,
-		version:   version,
-	}, nil
+	return &KeyStore{keys: keys}, nil
 }
 
 // KeyStore is an implementation of encid.KeyStore backed by a SQLite database.
+//
+// A KeyStore may hold the keys of many tenants,
+// each in its own namespace.
+// See [WithTenant] and [KeyStore.ForTenant].
 type KeyStore struct {
-	db        *sql.DB
-	newcipher func([]byte) (cipher.Block, error)
-	version   int
+	keys *keydb.KeyStore
 }
 
 var (
-	_ encid.KeyStore  = &KeyStore{}
-	_ encid.Versioner = &KeyStore{}
+	_ encid.KeyStore          = &KeyStore{}
+	_ encid.Versioner         = &KeyStore{}
+	_ encid.KeyVersioner      = &KeyStore{}
+	_ encid.DecoderBatcher    = &KeyStore{}
+	_ encid.KeyEncoder        = &KeyStore{}
+	_ encid.VersionedKeyStore = &KeyStore{}
 )
 
-func (ks *KeyStore) DecoderByID(ctx context.Context, id int64) (typ int, dec func(dst, src []byte), err error) {
-	const q = `SELECT typ, k FROM keys WHERE id = $1`
+// DecoderByID implements [encid.KeyStore].
+// It returns [encid.ErrRevoked] for a key that has been revoked.
+func (ks *KeyStore) DecoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), error) {
+	return ks.keys.DecoderByID(ctx, id)
+}
 
-	var k []byte
+// VersionedDecoderByID implements [encid.VersionedKeyStore].
+// It is like [KeyStore.DecoderByID]
+// but also returns the key's version,
+// from the same query.
+func (ks *KeyStore) VersionedDecoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), int, error) {
+	return ks.keys.VersionedDecoderByID(ctx, id)
+}
 
-	err = ks.db.QueryRowContext(ctx, q, id).Scan(&typ, &k)
-	if errors.Is(err, sql.ErrNoRows) {
-		return 0, nil, encid.ErrNotFound
-	}
-	if err != nil {
-		return 0, nil, errors.Wrapf(err, "retrieving key %d", id)
-	}
+// EncoderByID implements [encid.KeyEncoder].
+// It returns [encid.ErrRevoked] for a key that has been revoked.
+func (ks *KeyStore) EncoderByID(ctx context.Context, id int64) (int, func(dst, src []byte), error) {
+	return ks.keys.EncoderByID(ctx, id)
+}
 
-	ciph, err := ks.newcipher(k)
-	if err != nil {
-		return 0, nil, errors.Wrapf(err, "creating cipher for key %d", id)
-	}
+// DecodersByID implements [encid.DecoderBatcher].
+// It retrieves the requested keys a few hundred at a time,
+// in one query per batch.
+func (ks *KeyStore) DecodersByID(ctx context.Context, ids []int64) (map[int64]encid.Decoder, error) {
+	return ks.keys.DecodersByID(ctx, ids)
+}
 
-	return typ, ciph.Decrypt, nil
+// EncoderByType implements [encid.KeyStore].
+// It uses the latest active key of the given type.
+func (ks *KeyStore) EncoderByType(ctx context.Context, typ int) (int64, func(dst, src []byte), error) {
+	return ks.keys.EncoderByType(ctx, typ)
 }
 
-func (ks *KeyStore) EncoderByType(ctx context.Context, typ int) (id int64, enc func(dst, src []byte), err error) {
-	const q = `SELECT id, k FROM keys WHERE typ = $1 ORDER BY id DESC LIMIT 1`
+// VersionedEncoderByType implements [encid.VersionedKeyStore].
+// It is like [KeyStore.EncoderByType]
+// but also returns the key's version,
+// from the same query.
+func (ks *KeyStore) VersionedEncoderByType(ctx context.Context, typ int) (int64, func(dst, src []byte), int, error) {
+	return ks.keys.VersionedEncoderByType(ctx, typ)
+}
 
-	var k []byte
+// HasKeys tells whether the keystore has any keys of the given type,
+// in any state,
+// as opposed to only retired or revoked ones
+// (in which case [KeyStore.EncoderByType] fails too).
+func (ks *KeyStore) HasKeys(ctx context.Context, typ int) (bool, error) {
+	return ks.keys.HasKeys(ctx, typ)
+}
 
-	err = ks.db.QueryRowContext(ctx, q, typ).Scan(&id, &k)
-	if errors.Is(err, sql.ErrNoRows) {
-		return 0, nil, encid.ErrNotFound
-	}
-	if err != nil {
-		return 0, nil, errors.Wrapf(err, "retrieving key for type %d", typ)
-	}
+func (ks *KeyStore) Version() int {
+	return ks.keys.Version()
+}
 
-	ciph, err := ks.newcipher(k)
-	if err != nil {
-		return 0, nil, errors.Wrapf(err, "creating cipher for key %d", id)
-	}
+// KeyVersion implements [encid.KeyVersioner].
+// Each key's version is the version of the keystore at the time the key was created.
+func (ks *KeyStore) KeyVersion(ctx context.Context, id int64) (int, error) {
+	return ks.keys.KeyVersion(ctx, id)
+}
 
-	return id, ciph.Encrypt, nil
+// NewKey adds a new random key of the given type and size to the keystore,
+// returning its ID.
+// The key's version is the version of the keystore.
+func (ks *KeyStore) NewKey(ctx context.Context, typ, keysize int) (int64, error) {
+	return ks.keys.NewKey(ctx, typ, keysize)
 }
 
-func (ks *KeyStore) Version() int {
-	return ks.version
+// NewKeyVersion is like [KeyStore.NewKey]
+// but gives the new key the specified version
+// rather than the version of the keystore.
+// See [encid.KeyVersioner].
+func (ks *KeyStore) NewKeyVersion(ctx context.Context, typ, keysize, version int) (int64, error) {
+	return ks.keys.NewKeyVersion(ctx, typ, keysize, version)
 }
 
-func (ks *KeyStore) NewKey(ctx context.Context, typ, keysize int) (int64, error) {
-	k := make([]byte, keysize)
-	if _, err := rand.Read(k); err != nil {
-		return 0, errors.Wrap(err, "generating key")
-	}
+// Migrate brings the keystore up to version 2.
+//
+// Existing keys keep their version,
+// so strings encoded with version 1 keys continue to decode.
+// For each tenant and type whose latest active key is at version 1,
+// a new version 2 key is added,
+// of the same size,
+// so that subsequent encoding of that type uses version 2.
+//
+// Migrating a keystore that is already at version 2 is a no-op.
+func (ks *KeyStore) Migrate(ctx context.Context) error {
+	return ks.keys.Migrate(ctx)
+}
 
-	const q = `INSERT INTO keys (typ, k) VALUES ($1, $2)`
+// Rotate adds a new random key of the given type and size to the keystore,
+// returning its ID.
+// Other active keys of the same type become decode-only
+// (see [encid.KeyDecodeOnly]).
+func (ks *KeyStore) Rotate(ctx context.Context, typ, keysize int) (int64, error) {
+	return ks.keys.Rotate(ctx, typ, keysize)
+}
 
-	res, err := ks.db.ExecContext(ctx, q, typ, k)
-	if err != nil {
-		return 0, errors.Wrap(err, "inserting key")
-	}
+// Retire makes the key with the given ID decode-only
+// (see [encid.KeyDecodeOnly]).
+// Retiring a revoked key leaves it revoked.
+func (ks *KeyStore) Retire(ctx context.Context, id int64) error {
+	return ks.keys.Retire(ctx, id)
+}
 
-	return res.LastInsertId()
+// Revoke revokes the key with the given ID
+// (see [encid.KeyRevoked]).
+// Strings encoded with it can no longer be decoded.
+func (ks *KeyStore) Revoke(ctx context.Context, id int64) error {
+	return ks.keys.Revoke(ctx, id)
 }
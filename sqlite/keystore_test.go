@@ -65,6 +65,20 @@ func TestKeyStore(t *testing.T) {
 	}
 
 	testutil.EncodeDecode(ctx, t, ks, 2)
+
+	v1ID, err := ks.NewKeyVersion(ctx, 3, aes.BlockSize, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ver, err := ks.KeyVersion(ctx, v1ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ver != 1 {
+		t.Errorf("got key version %d, want 1", ver)
+	}
+
+	testutil.EncodeDecode(ctx, t, ks, 4)
 }
 
 func TestErrs(t *testing.T) {
@@ -120,3 +134,256 @@ func TestErrs(t *testing.T) {
 		}
 	})
 }
+
+func TestMigrate(t *testing.T) {
+	tmpdir, err := os.MkdirTemp("", "keystore_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ctx := context.Background()
+
+	filename := filepath.Join(tmpdir, "keystore.db")
+	ks, err := New(ctx, filename, aes.NewCipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a keystore created before version 2.
+	if _, err := ks.keys.DB().ExecContext(ctx, `UPDATE version SET version = 1`); err != nil {
+		t.Fatal(err)
+	}
+	oldID, err := ks.NewKeyVersion(ctx, 1, aes.BlockSize, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ks, err = New(ctx, filename, aes.NewCipher); err != nil {
+		t.Fatal(err)
+	}
+	if ks.Version() != 1 {
+		t.Fatalf("got version %d, want 1", ks.Version())
+	}
+
+	keyID, str, err := encid.Encode(ctx, ks, 1, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != oldID {
+		t.Fatalf("got key ID %d, want %d", keyID, oldID)
+	}
+
+	if err := ks.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ks.Version() != 2 {
+		t.Errorf("got version %d, want 2", ks.Version())
+	}
+
+	// Reopen to make sure the migration persisted.
+	ks, err = New(ctx, filename, aes.NewCipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ks.Version() != 2 {
+		t.Errorf("after reopening, got version %d, want 2", ks.Version())
+	}
+
+	typ, n, err := encid.Decode(ctx, ks, keyID, str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != 1 || n != 17 {
+		t.Errorf("got (%d, %d), want (1, 17)", typ, n)
+	}
+
+	newID, _, err := ks.EncoderByType(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newID == oldID {
+		t.Error("migration did not add a new key")
+	}
+	ver, err := ks.KeyVersion(ctx, newID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ver != 2 {
+		t.Errorf("got new key version %d, want 2", ver)
+	}
+
+	testutil.EncodeDecode(ctx, t, ks, 2)
+}
+
+func TestKeyStates(t *testing.T) {
+	tmpdir, err := os.MkdirTemp("", "keystore_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ctx := context.Background()
+
+	filename := filepath.Join(tmpdir, "keystore.db")
+	ks, err := New(ctx, filename, aes.NewCipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id1, err := ks.NewKey(ctx, 1, aes.BlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyID, str, err := encid.Encode(ctx, ks, 1, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != id1 {
+		t.Fatalf("got key ID %d, want %d", keyID, id1)
+	}
+
+	id2, err := ks.Rotate(ctx, 1, aes.BlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotID, _, err := ks.EncoderByType(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotID != id2 {
+		t.Errorf("after rotation, got key ID %d, want %d", gotID, id2)
+	}
+
+	// Strings encoded with the old key still decode.
+	if _, _, err := encid.Decode(ctx, ks, id1, str); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ks.Retire(ctx, id2); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = ks.EncoderByType(ctx, 1)
+	if !errors.Is(err, encid.ErrNotFound) {
+		t.Errorf("with all keys retired, got error %v, want %v", err, encid.ErrNotFound)
+	}
+
+	if err := ks.Revoke(ctx, id1); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = encid.Decode(ctx, ks, id1, str)
+	if !errors.Is(err, encid.ErrRevoked) {
+		t.Errorf("got error %v, want %v", err, encid.ErrRevoked)
+	}
+
+	// Retiring a revoked key does not reinstate it.
+	if err := ks.Retire(ctx, id1); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = ks.DecoderByID(ctx, id1)
+	if !errors.Is(err, encid.ErrRevoked) {
+		t.Errorf("after retiring revoked key, got error %v, want %v", err, encid.ErrRevoked)
+	}
+
+	if err := ks.Revoke(ctx, 1000); !errors.Is(err, encid.ErrNotFound) {
+		t.Errorf("revoking nonexistent key, got error %v, want %v", err, encid.ErrNotFound)
+	}
+}
+
+func TestSuite(t *testing.T) {
+	ctx := context.Background()
+
+	testutil.KeyStoreSuite(ctx, t, func(t *testing.T) (testutil.DBKeyStore, func(*testing.T) testutil.DBKeyStore) {
+		filename := filepath.Join(t.TempDir(), "keystore.db")
+
+		open := func(t *testing.T) testutil.DBKeyStore {
+			ks, err := New(ctx, filename, aes.NewCipher)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return ks
+		}
+
+		return open(t), open
+	})
+}
+
+func TestTenants(t *testing.T) {
+	ctx := context.Background()
+
+	ks, err := New(ctx, filepath.Join(t.TempDir(), "keystore.db"), aes.NewCipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		ctxA = WithTenant(ctx, "a")
+		ctxB = WithTenant(ctx, "b")
+	)
+
+	idA, err := ks.NewKey(ctxA, 1, aes.BlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idB, err := ks.ForTenant("b").NewKey(ctx, 1, aes.BlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each tenant encodes with its own key.
+	for _, c := range []struct {
+		ctx  context.Context
+		want int64
+	}{{ctxA, idA}, {ctxB, idB}} {
+		if id, _, err := ks.EncoderByType(c.ctx, 1); err != nil || id != c.want {
+			t.Errorf("tenant %q: got key (%d, %v), want %d", Tenant(c.ctx), id, err, c.want)
+		}
+	}
+	if _, _, err := ks.EncoderByType(ctx, 1); !errors.Is(err, encid.ErrNotFound) {
+		t.Errorf("default tenant: got error %v, want %v", err, encid.ErrNotFound)
+	}
+
+	keyID, str, err := encid.Encode(ctxA, ks, 1, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, n, err := encid.Decode(ctxA, ks, keyID, str); err != nil || n != 17 {
+		t.Errorf("decoding in tenant a: got (%d, %v), want 17", n, err)
+	}
+	if _, _, err := encid.Decode(ctxB, ks, keyID, str); !errors.Is(err, encid.ErrNotFound) {
+		t.Errorf("decoding in tenant b: got error %v, want %v", err, encid.ErrNotFound)
+	}
+
+	// The tenant given to ForTenant overrides the context.
+	if _, n, err := encid.Decode(ctxB, ks.ForTenant("a"), keyID, str); err != nil || n != 17 {
+		t.Errorf("decoding with ForTenant: got (%d, %v), want 17", n, err)
+	}
+
+	// Keys are revoked and rotated per tenant.
+	if err := ks.Revoke(ctxB, idA); !errors.Is(err, encid.ErrNotFound) {
+		t.Errorf("revoking tenant a's key in tenant b: got error %v, want %v", err, encid.ErrNotFound)
+	}
+	if err := ks.Revoke(ctxB, idB); err != nil {
+		t.Fatal(err)
+	}
+	if _, n, err := encid.Decode(ctxA, ks, keyID, str); err != nil || n != 17 {
+		t.Errorf("decoding in tenant a after revoking in b: got (%d, %v), want 17", n, err)
+	}
+
+	newA, err := ks.Rotate(ctxA, 1, aes.BlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _, err := ks.EncoderByType(ctxA, 1); err != nil || id != newA {
+		t.Errorf("after rotation: got key (%d, %v), want %d", id, err, newA)
+	}
+	if _, _, err := ks.EncoderByType(ctxB, 1); !errors.Is(err, encid.ErrNotFound) {
+		t.Errorf("tenant b after rotating a: got error %v, want %v", err, encid.ErrNotFound)
+	}
+
+	results := encid.DecodeBatch(ctxB, ks, []encid.Encoded{{KeyID: keyID, Str: str}})
+	if !errors.Is(results[0].Err, encid.ErrNotFound) {
+		t.Errorf("batch decoding in tenant b: got error %v, want %v", results[0].Err, encid.ErrNotFound)
+	}
+}
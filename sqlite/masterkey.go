@@ -0,0 +1,65 @@
+package sqlite
+
+import (
+	"context"
+
+	"github.com/bobg/encid/internal/keydb"
+)
+
+// ErrNoMasterKey is the error produced when using a wrapped key
+// in a keystore that has no master key.
+// See [KeyStore.SetMasterKey].
+var ErrNoMasterKey = keydb.ErrNoMasterKey
+
+// ErrWrongMasterKey is the error produced when a wrapped key
+// cannot be unwrapped with the master key,
+// e.g. because the master key is not the one it was wrapped with.
+// See [KeyStore.SetMasterKey].
+var ErrWrongMasterKey = keydb.ErrWrongMasterKey
+
+// SetMasterKey sets the master key for the keystore.
+// The master key is a key-encryption key:
+// while it is set,
+// new keys are stored "wrapped" (encrypted) with it,
+// and unwrapped in memory when needed.
+// This way,
+// reading the keystore file is not enough to decrypt any IDs.
+//
+// The master key must be 16, 24, or 32 bytes long.
+// It can be derived from a passphrase with [KeyStore.PassphraseKey].
+// A nil master key clears it.
+//
+// Keys that were stored before a master key was set
+// remain unwrapped until [KeyStore.Rewrap] is called.
+// Using a wrapped key in a keystore with no master key
+// produces an error wrapping [ErrNoMasterKey].
+//
+// If the keystore already has wrapped keys,
+// SetMasterKey checks that kek unwraps them,
+// producing an error wrapping [ErrWrongMasterKey] if not.
+//
+// SetMasterKey must not be called concurrently with other methods.
+func (ks *KeyStore) SetMasterKey(ctx context.Context, kek []byte) error {
+	return ks.keys.SetMasterKey(ctx, kek)
+}
+
+// PassphraseKey derives a master key from a passphrase,
+// suitable for [KeyStore.SetMasterKey] and [KeyStore.Rewrap].
+// It uses scrypt with a random salt that is stored in the keystore,
+// and that is created on first use.
+func (ks *KeyStore) PassphraseKey(ctx context.Context, passphrase []byte) ([]byte, error) {
+	return ks.keys.PassphraseKey(ctx, passphrase)
+}
+
+// Rewrap re-encrypts all the keys in the keystore, of every tenant, under a new master key,
+// and makes that the keystore's master key.
+// See [KeyStore.SetMasterKey].
+//
+// Keys that are currently wrapped must be wrapped with the current master key.
+// If kek is nil,
+// all keys are stored unwrapped.
+//
+// Rewrap must not be called concurrently with other methods.
+func (ks *KeyStore) Rewrap(ctx context.Context, kek []byte) error {
+	return ks.keys.Rewrap(ctx, kek)
+}
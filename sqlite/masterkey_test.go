@@ -0,0 +1,167 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bobg/encid"
+	"github.com/bobg/encid/internal/keydb"
+	"github.com/bobg/encid/testutil"
+)
+
+func TestMasterKey(t *testing.T) {
+	tmpdir, err := os.MkdirTemp("", "keystore_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ctx := context.Background()
+
+	filename := filepath.Join(tmpdir, "keystore.db")
+	ks, err := New(ctx, filename, aes.NewCipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id1, err := ks.NewKey(ctx, 1, aes.BlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, str1, err := encid.Encode(ctx, ks, 1, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kek1 := bytes.Repeat([]byte{1}, 32)
+	if err := ks.SetMasterKey(ctx, kek1); err != nil {
+		t.Fatal(err)
+	}
+
+	id2, err := ks.NewKey(ctx, 2, aes.BlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, str2, err := encid.Encode(ctx, ks, 2, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		k       []byte
+		wrapped bool
+	)
+	if err := ks.keys.DB().QueryRowContext(ctx, `SELECT k, wrapped FROM keys WHERE id = $1`, id2).Scan(&k, &wrapped); err != nil {
+		t.Fatal(err)
+	}
+	if !wrapped {
+		t.Error("new key is not wrapped")
+	}
+	if len(k) != aes.BlockSize+keydb.WrapOverhead {
+		t.Errorf("got wrapped key length %d, want %d", len(k), aes.BlockSize+keydb.WrapOverhead)
+	}
+
+	checkDecode := func(t *testing.T, ks *KeyStore) {
+		t.Helper()
+		if _, n, err := encid.Decode(ctx, ks, id1, str1); err != nil || n != 17 {
+			t.Errorf("decoding %s: got (%d, %v), want 17", str1, n, err)
+		}
+		if _, n, err := encid.Decode(ctx, ks, id2, str2); err != nil || n != 42 {
+			t.Errorf("decoding %s: got (%d, %v), want 42", str2, n, err)
+		}
+	}
+
+	checkDecode(t, ks)
+
+	ks, err = New(ctx, filename, aes.NewCipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = encid.Decode(ctx, ks, id2, str2)
+	if !errors.Is(err, ErrNoMasterKey) {
+		t.Errorf("got error %v, want %v", err, ErrNoMasterKey)
+	}
+
+	if err := ks.SetMasterKey(ctx, kek1); err != nil {
+		t.Fatal(err)
+	}
+
+	kek2, err := ks.PassphraseKey(ctx, []byte("correct horse battery staple"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Rewrap(ctx, kek2); err != nil {
+		t.Fatal(err)
+	}
+
+	checkDecode(t, ks)
+
+	ks, err = New(ctx, filename, aes.NewCipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.SetMasterKey(ctx, kek1); !errors.Is(err, ErrWrongMasterKey) {
+		t.Errorf("setting old master key: got error %v, want %v", err, ErrWrongMasterKey)
+	}
+	if _, _, err := encid.Decode(ctx, ks, id1, str1); !errors.Is(err, ErrNoMasterKey) {
+		t.Errorf("decoding after setting old master key: got error %v, want %v", err, ErrNoMasterKey)
+	}
+
+	kek2again, err := ks.PassphraseKey(ctx, []byte("correct horse battery staple"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(kek2, kek2again) {
+		t.Error("passphrase key not reproducible")
+	}
+	if err := ks.SetMasterKey(ctx, kek2again); err != nil {
+		t.Fatal(err)
+	}
+
+	checkDecode(t, ks)
+	testutil.EncodeDecode(ctx, t, ks, 3)
+
+	// A wrapped key is bound to its ID
+	// and does not unwrap in another row.
+	var k1, k2 []byte
+	if err := ks.keys.DB().QueryRowContext(ctx, `SELECT k FROM keys WHERE id = $1`, id1).Scan(&k1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.keys.DB().QueryRowContext(ctx, `SELECT k FROM keys WHERE id = $1`, id2).Scan(&k2); err != nil {
+		t.Fatal(err)
+	}
+	swap := func(a, b []byte) {
+		t.Helper()
+		if _, err := ks.keys.DB().ExecContext(ctx, `UPDATE keys SET k = $1 WHERE id = $2`, a, id1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ks.keys.DB().ExecContext(ctx, `UPDATE keys SET k = $1 WHERE id = $2`, b, id2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	swap(k2, k1)
+	if _, _, err := encid.Decode(ctx, ks, id1, str1); !errors.Is(err, ErrWrongMasterKey) {
+		t.Errorf("decoding with swapped wrapped keys: got error %v, want %v", err, ErrWrongMasterKey)
+	}
+	swap(k1, k2)
+
+	if err := ks.Rewrap(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err = New(ctx, filename, aes.NewCipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkDecode(t, ks)
+
+	if err := ks.SetMasterKey(ctx, []byte("too short")); err == nil {
+		t.Error("setting short master key: got nil error")
+	}
+}
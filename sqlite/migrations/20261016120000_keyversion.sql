@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE keys ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+UPDATE keys SET version = (SELECT version FROM version WHERE singleton = 0);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE keys DROP COLUMN version;
+-- +goose StatementEnd
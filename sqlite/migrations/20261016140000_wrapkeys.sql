@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE keys ADD COLUMN wrapped INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS kdf (
+  singleton INTEGER NOT NULL PRIMARY KEY,
+  salt BLOB NOT NULL,
+  CHECK (singleton = 0)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS kdf;
+
+ALTER TABLE keys DROP COLUMN wrapped;
+-- +goose StatementEnd
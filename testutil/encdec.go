@@ -28,6 +28,21 @@ func EncodeDecode(ctx context.Context, t *testing.T, ks encid.KeyStore, numTypes
 				t.Logf("Decode(Encode(%d, %d)) = (%d, %d)", typ, n, gotTyp, gotN)
 				return false
 			}
+
+			tok, err := encid.EncodeToken(ctx, ks, typ, n)
+			if err != nil {
+				t.Logf("Error encoding token (%d, %d): %s", typ, n, err)
+				return false
+			}
+			gotTyp, gotN, err = encid.DecodeToken(ctx, ks, tok)
+			if err != nil {
+				t.Logf("Error decoding token %s: %s\n", tok, err)
+				return false
+			}
+			if gotTyp != typ || gotN != n {
+				t.Logf("DecodeToken(EncodeToken(%d, %d)) = (%d, %d)", typ, n, gotTyp, gotN)
+				return false
+			}
 		}
 		return true
 	}, nil)
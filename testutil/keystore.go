@@ -11,6 +11,10 @@ import (
 
 type KeyStore struct {
 	NumTypes, Ver int
+
+	// KeyVers optionally maps key IDs to versions,
+	// overriding Ver for those keys.
+	KeyVers map[int64]int
 }
 
 func (tks KeyStore) cipherByID(keyID int64) (cipher.Block, error) {
@@ -23,16 +27,28 @@ func (tks KeyStore) cipherByID(keyID int64) (cipher.Block, error) {
 	return aes.NewCipher(buf[:])
 }
 
-func (tks KeyStore) DecoderByID(_ context.Context, keyID int64) (int, func(dst, src []byte), error) {
+func (tks KeyStore) typeByID(keyID int64) int {
 	n := tks.NumTypes
 	if n < 1 {
 		n = 2
 	}
+	return int(keyID) % n
+}
+
+func (tks KeyStore) DecoderByID(_ context.Context, keyID int64) (int, func(dst, src []byte), error) {
+	ciph, err := tks.cipherByID(keyID)
+	if err != nil {
+		return 0, nil, err
+	}
+	return tks.typeByID(keyID), ciph.Decrypt, err
+}
+
+func (tks KeyStore) EncoderByID(_ context.Context, keyID int64) (int, func(dst, src []byte), error) {
 	ciph, err := tks.cipherByID(keyID)
 	if err != nil {
 		return 0, nil, err
 	}
-	return int(keyID) % n, ciph.Decrypt, err
+	return tks.typeByID(keyID), ciph.Encrypt, nil
 }
 
 func (tks KeyStore) EncoderByType(_ context.Context, typ int) (int64, func(dst, src []byte), error) {
@@ -47,3 +63,13 @@ func (tks KeyStore) EncoderByType(_ context.Context, typ int) (int64, func(dst,
 func (tks KeyStore) Version() int {
 	return tks.Ver
 }
+
+func (tks KeyStore) KeyVersion(_ context.Context, keyID int64) (int, error) {
+	if keyID > 999999 {
+		return 0, encid.ErrNotFound
+	}
+	if v, ok := tks.KeyVers[keyID]; ok {
+		return v, nil
+	}
+	return tks.Ver, nil
+}
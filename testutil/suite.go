@@ -0,0 +1,388 @@
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"errors"
+	"testing"
+
+	"github.com/bobg/encid"
+)
+
+// DBKeyStore is the interface implemented by the database-backed keystores in this module.
+type DBKeyStore interface {
+	encid.KeyStore
+	encid.Versioner
+	encid.KeyVersioner
+	encid.VersionedKeyStore
+
+	NewKey(ctx context.Context, typ, keysize int) (int64, error)
+	NewKeyVersion(ctx context.Context, typ, keysize, version int) (int64, error)
+	HasKeys(ctx context.Context, typ int) (bool, error)
+	Migrate(context.Context) error
+	Rotate(ctx context.Context, typ, keysize int) (int64, error)
+	Retire(context.Context, int64) error
+	Revoke(context.Context, int64) error
+	DecodersByID(context.Context, []int64) (map[int64]encid.Decoder, error)
+	EncoderByID(context.Context, int64) (int, func(dst, src []byte), error)
+	SetMasterKey(context.Context, []byte) error
+	PassphraseKey(context.Context, []byte) ([]byte, error)
+	Rewrap(context.Context, []byte) error
+}
+
+// KeyStoreSuite runs tests common to all implementations of [DBKeyStore].
+//
+// The create function must create a new, empty keystore.
+// It returns the keystore
+// and a function for opening another handle on the same underlying database.
+func KeyStoreSuite(ctx context.Context, t *testing.T, create func(*testing.T) (DBKeyStore, func(*testing.T) DBKeyStore)) {
+	t.Run("Basic", func(t *testing.T) {
+		ks, _ := create(t)
+
+		_, _, err := ks.DecoderByID(ctx, 1)
+		if !errors.Is(err, encid.ErrNotFound) {
+			t.Errorf("got %v, want %v", err, encid.ErrNotFound)
+		}
+
+		_, _, err = ks.EncoderByType(ctx, 1)
+		if !errors.Is(err, encid.ErrNotFound) {
+			t.Errorf("got %v, want %v", err, encid.ErrNotFound)
+		}
+
+		if ks.Version() != 2 {
+			t.Errorf("got version %d, want 2", ks.Version())
+		}
+
+		id, err := ks.NewKey(ctx, 1, aes.BlockSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		typ, _, err := ks.DecoderByID(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != 1 {
+			t.Errorf("got type %d, want 1", typ)
+		}
+
+		gotID, _, err := ks.EncoderByType(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotID != id {
+			t.Errorf("got ID %d, want %d", gotID, id)
+		}
+
+		if _, err := ks.NewKey(ctx, 2, aes.BlockSize); err != nil {
+			t.Fatal(err)
+		}
+
+		EncodeDecode(ctx, t, ks, 3)
+	})
+
+	t.Run("Versions", func(t *testing.T) {
+		ks, _ := create(t)
+
+		v1ID, err := ks.NewKeyVersion(ctx, 1, aes.BlockSize, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ver, err := ks.KeyVersion(ctx, v1ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ver != 1 {
+			t.Errorf("got key version %d, want 1", ver)
+		}
+		if _, _, ver, err := ks.VersionedDecoderByID(ctx, v1ID); err != nil || ver != 1 {
+			t.Errorf("got key version (%d, %v) from VersionedDecoderByID, want 1", ver, err)
+		}
+		if gotID, _, ver, err := ks.VersionedEncoderByType(ctx, 1); err != nil || gotID != v1ID || ver != 1 {
+			t.Errorf("got key %d version (%d, %v) from VersionedEncoderByType, want key %d version 1", gotID, ver, err, v1ID)
+		}
+
+		keyID, str, err := encid.Encode(ctx, ks, 1, 17)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ks.Migrate(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		newID, _, err := ks.EncoderByType(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if newID == v1ID {
+			t.Error("migration did not add a new key")
+		}
+		ver, err = ks.KeyVersion(ctx, newID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ver != 2 {
+			t.Errorf("got new key version %d, want 2", ver)
+		}
+		if _, _, ver, err := ks.VersionedEncoderByType(ctx, 1); err != nil || ver != 2 {
+			t.Errorf("got new key version (%d, %v) from VersionedEncoderByType, want 2", ver, err)
+		}
+
+		if _, n, err := encid.Decode(ctx, ks, keyID, str); err != nil || n != 17 {
+			t.Errorf("decoding %s after migration: got (%d, %v), want 17", str, n, err)
+		}
+
+		EncodeDecode(ctx, t, ks, 2)
+	})
+
+	t.Run("States", func(t *testing.T) {
+		ks, _ := create(t)
+
+		id1, err := ks.NewKey(ctx, 1, aes.BlockSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, str, err := encid.Encode(ctx, ks, 1, 17)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		id2, err := ks.Rotate(ctx, 1, aes.BlockSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotID, _, err := ks.EncoderByType(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotID != id2 {
+			t.Errorf("after rotation, got key ID %d, want %d", gotID, id2)
+		}
+		if _, _, err := encid.Decode(ctx, ks, id1, str); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ks.Retire(ctx, id2); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := ks.EncoderByType(ctx, 1); !errors.Is(err, encid.ErrNotFound) {
+			t.Errorf("with all keys retired, got error %v, want %v", err, encid.ErrNotFound)
+		}
+		if has, err := ks.HasKeys(ctx, 1); err != nil || !has {
+			t.Errorf("with all keys retired, HasKeys got (%v, %v), want true", has, err)
+		}
+		if has, err := ks.HasKeys(ctx, 2); err != nil || has {
+			t.Errorf("for type without keys, HasKeys got (%v, %v), want false", has, err)
+		}
+
+		if err := ks.Revoke(ctx, id1); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := encid.Decode(ctx, ks, id1, str); !errors.Is(err, encid.ErrRevoked) {
+			t.Errorf("got error %v, want %v", err, encid.ErrRevoked)
+		}
+		if err := ks.Retire(ctx, id1); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := ks.DecoderByID(ctx, id1); !errors.Is(err, encid.ErrRevoked) {
+			t.Errorf("after retiring revoked key, got error %v, want %v", err, encid.ErrRevoked)
+		}
+
+		if err := ks.Revoke(ctx, id2+1000); !errors.Is(err, encid.ErrNotFound) {
+			t.Errorf("revoking nonexistent key, got error %v, want %v", err, encid.ErrNotFound)
+		}
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		ks, _ := create(t)
+
+		id1, err := ks.NewKey(ctx, 1, aes.BlockSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id2, err := ks.NewKey(ctx, 2, aes.BlockSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var inps []encid.Encoded
+		for _, r := range encid.EncodeBatch(ctx, ks, 1, []int64{3, 4}) {
+			if r.Err != nil {
+				t.Fatal(r.Err)
+			}
+			inps = append(inps, r.Encoded)
+		}
+		for _, r := range encid.EncodeBatch(ctx, ks, 2, []int64{5}) {
+			if r.Err != nil {
+				t.Fatal(r.Err)
+			}
+			inps = append(inps, r.Encoded)
+		}
+		inps = append(inps, encid.Encoded{KeyID: id2 + 1000, Str: inps[0].Str})
+
+		decoders, err := ks.DecodersByID(ctx, []int64{id1, id2, id2 + 1000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(decoders) != 2 {
+			t.Errorf("got %d decoders, want 2", len(decoders))
+		}
+		if d := decoders[id2]; d.Err != nil || d.Type != 2 || d.Version != 2 {
+			t.Errorf("got decoder (type %d, version %d, error %v) for key %d, want (2, 2, nil)", d.Type, d.Version, d.Err, id2)
+		}
+
+		if err := ks.Revoke(ctx, id2); err != nil {
+			t.Fatal(err)
+		}
+
+		results := encid.DecodeBatch(ctx, ks, inps)
+		if len(results) != 4 {
+			t.Fatalf("got %d results, want 4", len(results))
+		}
+		for i, want := range []int64{3, 4} {
+			if r := results[i]; r.Err != nil || r.Type != 1 || r.N != want {
+				t.Errorf("result %d: got (%d, %d, %v), want (1, %d, nil)", i, r.Type, r.N, r.Err, want)
+			}
+		}
+		if !errors.Is(results[2].Err, encid.ErrRevoked) {
+			t.Errorf("result 2: got error %v, want %v", results[2].Err, encid.ErrRevoked)
+		}
+		if !errors.Is(results[3].Err, encid.ErrNotFound) {
+			t.Errorf("result 3: got error %v, want %v", results[3].Err, encid.ErrNotFound)
+		}
+	})
+
+	t.Run("LargeBatch", func(t *testing.T) {
+		ks, _ := create(t)
+
+		id, err := ks.NewKey(ctx, 1, aes.BlockSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// More IDs than either database allows as parameters to one query.
+		ids := make([]int64, 70000)
+		for i := range ids {
+			ids[i] = id + int64(i)
+		}
+
+		decoders, err := ks.DecodersByID(ctx, ids)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(decoders) != 1 {
+			t.Errorf("got %d decoders, want 1", len(decoders))
+		}
+		if d := decoders[id]; d.Err != nil || d.Type != 1 {
+			t.Errorf("got decoder (type %d, error %v) for key %d, want (1, nil)", d.Type, d.Err, id)
+		}
+	})
+
+	t.Run("Short", func(t *testing.T) {
+		ks, _ := create(t)
+
+		short := encid.WithShortFormat()
+
+		keyID, err := ks.NewKey(ctx, 1, aes.BlockSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		longTok, err := encid.EncodeToken(ctx, ks, 1, 17)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shortTok, err := encid.EncodeToken(ctx, ks, 1, 17, short)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(shortTok) >= len(longTok) {
+			t.Errorf("got short token %s no shorter than long token %s", shortTok, longTok)
+		}
+
+		_, str, err := encid.Encode(ctx, ks, 1, 17, short)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, n, err := encid.Decode(ctx, ks, keyID, str, short); err != nil || n != 17 {
+			t.Errorf("decoding %s: got (%d, %v), want 17", str, n, err)
+		}
+		for _, tok := range []string{longTok, shortTok} {
+			if _, n, err := encid.DecodeToken(ctx, ks, tok, short); err != nil || n != 17 {
+				t.Errorf("decoding token %s: got (%d, %v), want 17", tok, n, err)
+			}
+		}
+
+		// Batches get the encryption functions that the short format needs.
+		decoders, err := ks.DecodersByID(ctx, []int64{keyID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoders[keyID].Encrypt == nil {
+			t.Error("got no Encrypt function from DecodersByID")
+		}
+		if r := encid.DecodeBatch(ctx, ks, []encid.Encoded{{KeyID: keyID, Str: str}}, short)[0]; r.Err != nil || r.N != 17 {
+			t.Errorf("batch decoding %s: got (%d, %v), want 17", str, r.N, r.Err)
+		}
+
+		if err := ks.Revoke(ctx, keyID); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := encid.DecodeToken(ctx, ks, shortTok, short); !errors.Is(err, encid.ErrRevoked) {
+			t.Errorf("decoding token with revoked key: got error %v, want %v", err, encid.ErrRevoked)
+		}
+	})
+
+	t.Run("MasterKey", func(t *testing.T) {
+		ks, reopen := create(t)
+
+		kek := bytes.Repeat([]byte{1}, 32)
+		if err := ks.SetMasterKey(ctx, kek); err != nil {
+			t.Fatal(err)
+		}
+
+		keyID, str, err := encodeWithNewKey(ctx, ks, 1, 17)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ks = reopen(t)
+		if _, _, err := encid.Decode(ctx, ks, keyID, str); err == nil {
+			t.Error("decoding without master key: got nil error")
+		}
+
+		if err := ks.SetMasterKey(ctx, kek); err != nil {
+			t.Fatal(err)
+		}
+		newKEK, err := ks.PassphraseKey(ctx, []byte("correct horse battery staple"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ks.Rewrap(ctx, newKEK); err != nil {
+			t.Fatal(err)
+		}
+
+		ks = reopen(t)
+		if err := ks.SetMasterKey(ctx, kek); err == nil {
+			t.Error("setting old master key after rewrap: got nil error")
+		}
+		newKEK, err = ks.PassphraseKey(ctx, []byte("correct horse battery staple"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ks.SetMasterKey(ctx, newKEK); err != nil {
+			t.Fatal(err)
+		}
+		if _, n, err := encid.Decode(ctx, ks, keyID, str); err != nil || n != 17 {
+			t.Errorf("decoding %s after rewrap: got (%d, %v), want 17", str, n, err)
+		}
+	})
+}
+
+func encodeWithNewKey(ctx context.Context, ks DBKeyStore, typ int, n int64) (int64, string, error) {
+	if _, err := ks.NewKey(ctx, typ, aes.BlockSize); err != nil {
+		return 0, "", err
+	}
+	return encid.Encode(ctx, ks, typ, n)
+}
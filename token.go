@@ -0,0 +1,123 @@
+package encid
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/bobg/basexx/v2"
+	"github.com/bobg/errors"
+)
+
+// keyIDSize is the number of bytes a key ID may occupy in a token.
+const keyIDSize = 8
+
+// EncodeToken encodes a number n using a key of the given type from the given keystore.
+// It is like [Encode],
+// but instead of returning the key ID separately from the encrypted string,
+// it embeds the key ID in the result,
+// producing a single self-describing "token."
+// Decode the token with [DecodeToken].
+//
+// The token is expressed in base 30, like the output of Encode,
+// unless a different base is chosen with [WithBase].
+func EncodeToken(ctx context.Context, ks KeyStore, typ int, n int64, opts ...Option) (string, error) {
+	return newConfig(ks, opts).encodeToken(ctx, typ, n)
+}
+
+// EncodeToken50 is the same as EncodeToken but it expresses the token in base 50.
+// See [Encode50].
+func EncodeToken50(ctx context.Context, ks KeyStore, typ int, n int64) (string, error) {
+	return encodeToken(ctx, ks, typ, n, rand.Reader, basexx.Base50)
+}
+
+func encodeToken(ctx context.Context, ks KeyStore, typ int, n int64, randBytes io.Reader, base basexx.Base) (string, error) {
+	keyID, block, err := encodeBlock(ctx, ks, typ, n, randBytes, blockOpts{})
+	if err != nil {
+		return "", err
+	}
+	tok, err := tokenBytes(keyID, block)
+	if err != nil {
+		return "", err
+	}
+	return convertBlock(tok, base)
+}
+
+// tokenBytes produces the binary form of a token:
+// the big-endian key ID followed by the encrypted block.
+// Numerically this is keyID*2^(8*len(block)) + block,
+// so the conversion to the output base dropping leading zeroes does no harm.
+// The key ID must be positive,
+// so that the length of a token tells its format (see decodeToken).
+func tokenBytes(keyID int64, block []byte) ([]byte, error) {
+	if keyID < 1 {
+		return nil, fmt.Errorf("cannot embed key ID %d in token, must be positive", keyID)
+	}
+	buf := make([]byte, keyIDSize+len(block))
+	binary.BigEndian.PutUint64(buf[:keyIDSize], uint64(keyID))
+	copy(buf[keyIDSize:], block)
+	return buf, nil
+}
+
+// DecodeToken decodes a token produced by EncodeToken.
+// It looks up the key whose ID is embedded in the token
+// and produces the type of that key and the bare int64 value that was encrypted.
+// As a convenience, it maps the input string to all lowercase before decoding
+// (unless a different base is chosen with [WithBase]).
+// The options must match those given to EncodeToken.
+func DecodeToken(ctx context.Context, ks KeyStore, tok string, opts ...Option) (int, int64, error) {
+	return newConfig(ks, opts).decodeToken(ctx, tok)
+}
+
+// DecodeToken50 decodes a token produced by EncodeToken50.
+// Unlike DecodeToken, this does not map the input to lowercase first,
+// since base50 strings are case-sensitive.
+func DecodeToken50(ctx context.Context, ks KeyStore, tok string) (int, int64, error) {
+	return decodeToken(ctx, ks, tok, basexx.Base50, blockOpts{})
+}
+
+func decodeToken(ctx context.Context, ks KeyStore, tok string, base basexx.Base, bo blockOpts) (int, int64, error) {
+	bin, err := convertInput(tok, base)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	blockSize := aes.BlockSize
+	if bo.short && len(bin) <= keyIDSize+shortBlockSize {
+		// Long tokens are never this short,
+		// since key IDs in tokens are positive.
+		blockSize = shortBlockSize
+	}
+
+	keyID, block, err := splitToken(bin, blockSize)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return decodeBlock(ctx, ks, keyID, block, bo)
+}
+
+// splitToken separates the key ID in a binary token from the encrypted block,
+// which is blockSize bytes long.
+func splitToken(bin []byte, blockSize int) (int64, []byte, error) {
+	if len(bin) > keyIDSize+blockSize {
+		return 0, nil, errors.Wrapf(ErrInvalidToken, "token too long (%d bytes)", len(bin))
+	}
+	if len(bin) <= blockSize {
+		return 0, nil, errors.Wrap(ErrInvalidToken, "no key ID in token")
+	}
+
+	var idbuf [keyIDSize]byte
+	split := len(bin) - blockSize
+	copy(idbuf[keyIDSize-split:], bin[:split])
+
+	keyID := int64(binary.BigEndian.Uint64(idbuf[:]))
+	if keyID < 1 {
+		return 0, nil, errors.Wrap(ErrInvalidToken, "invalid key ID in token")
+	}
+
+	return keyID, bin[split:], nil
+}
@@ -0,0 +1,88 @@
+package encid
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/bobg/errors"
+)
+
+// WrongTypeError is the error produced when decoding an input
+// whose key does not have one of the expected types.
+// See [WithTypes] and [WithPrefix].
+//
+// It matches [ErrWrongType] with [errors.Is].
+type WrongTypeError struct {
+	// Got is the type of the key.
+	Got int
+
+	// Want is the expected type or types.
+	Want []int
+}
+
+func (e *WrongTypeError) Error() string {
+	if len(e.Want) == 1 {
+		return fmt.Sprintf("%s: got type %d, want %d", ErrWrongType, e.Got, e.Want[0])
+	}
+	return fmt.Sprintf("%s: got type %d, want one of %v", ErrWrongType, e.Got, e.Want)
+}
+
+// Is tells whether target is [ErrWrongType].
+func (e *WrongTypeError) Is(target error) bool {
+	return target == ErrWrongType
+}
+
+// WithTypes is an option that makes decoding accept only inputs
+// encoded with keys of the given types.
+// Decoding any other input produces a [*WrongTypeError].
+func WithTypes(types ...int) Option {
+	return func(c *config) {
+		c.types = types
+	}
+}
+
+// checkType checks typ against the types given with WithTypes, if any.
+func (c *config) checkType(typ int) error {
+	if len(c.types) == 0 || slices.Contains(c.types, typ) {
+		return nil
+	}
+	return &WrongTypeError{Got: typ, Want: c.types}
+}
+
+// DecodeAs decodes a keyID/string pair produced by [Encode]
+// using the keystore of T,
+// and checks that the key has type T.Type().
+// If it doesn't,
+// the error is a [*WrongTypeError]
+// and the ID is zero.
+func DecodeAs[T Kind](ctx context.Context, keyID int64, inp string, opts ...Option) (ID[T], error) {
+	var k T
+	opts = append(slices.Clip(opts), WithTypes(k.Type()))
+	_, n, err := newConfig(k.KeyStore(), opts).decode(ctx, keyID, inp)
+	if err != nil {
+		return 0, err
+	}
+	return ID[T](n), nil
+}
+
+// DecodeTokenAs decodes a token produced by [EncodeToken]
+// using the keystore of T,
+// and checks that the key has type T.Type().
+// If it doesn't,
+// the error is a [*WrongTypeError]
+// and the ID is zero.
+func DecodeTokenAs[T Kind](ctx context.Context, tok string, opts ...Option) (ID[T], error) {
+	var k T
+	opts = append(slices.Clip(opts), WithTypes(k.Type()))
+	_, n, err := newConfig(k.KeyStore(), opts).decodeToken(ctx, tok)
+	if err != nil {
+		return 0, err
+	}
+	return ID[T](n), nil
+}
+
+// wrongPrefix produces the error for an input whose prefix belongs to a different type than its key.
+func wrongPrefix(prefix string, typ, prefixTyp int) error {
+	return errors.Wrapf(&WrongTypeError{Got: typ, Want: []int{prefixTyp}}, "prefix %q", prefix)
+}